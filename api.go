@@ -61,4 +61,39 @@ type Client interface {
 	// ReadFIFOQueue reads the contents of a First-In-First-Out (FIFO) queue
 	// of register in a remote device and returns FIFO value register.
 	ReadFIFOQueue(slaveID byte, address uint16) (results []byte, err error)
+
+	// custom
+
+	// Invoke performs a user defined function, request encodes the request
+	// pdu data and response decodes the response pdu data, response can be
+	// nil if you do not care about it. Declare the function with
+	// WithCustomFunction on serial provider so that its response can be framed.
+	Invoke(slaveID byte, request CustomRequest, response CustomResponse) error
+}
+
+// NodeStorage register storage of a slave node, the server dispatches
+// requests to it. NodeRegister is the in memory implementation, implement
+// it to back the slave with database, plc runtime or live process data.
+// Return ExceptionError to reply the exception code, other errors are
+// replied as server device failure.
+type NodeStorage interface {
+	// SlaveID returns the slave id of the node.
+	SlaveID() byte
+
+	// ReadCoils returns the coils status packed into bytes, lsb first.
+	ReadCoils(address, quantity uint16) ([]byte, error)
+	// WriteCoils writes the coils status packed into bytes, lsb first.
+	WriteCoils(address, quantity uint16, value []byte) error
+	// ReadDiscretes returns the discrete inputs status packed into bytes, lsb first.
+	ReadDiscretes(address, quantity uint16) ([]byte, error)
+
+	// ReadInputsBytes returns the input registers value, big endian.
+	ReadInputsBytes(address, quantity uint16) ([]byte, error)
+	// ReadHoldingsBytes returns the holding registers value, big endian.
+	ReadHoldingsBytes(address, quantity uint16) ([]byte, error)
+	// WriteHoldingsBytes writes the holding registers value, big endian.
+	WriteHoldingsBytes(address, quantity uint16, value []byte) error
+	// MaskWriteHolding modify a holding register with
+	// (value & andMask) | (orMask & ^andMask).
+	MaskWriteHolding(address, andMask, orMask uint16) error
 }
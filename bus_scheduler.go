@@ -0,0 +1,349 @@
+package modbus
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// bus scheduler errors
+var (
+	// ErrQueueFull request rejected since the scheduler queue is full.
+	ErrQueueFull = errors.New("modbus: request queue is full")
+	// ErrSchedulerClosed request after the scheduler closed.
+	ErrSchedulerClosed = errors.New("modbus: scheduler closed")
+)
+
+// Priority 总线请求优先级类别, 值越小越优先
+type Priority int
+
+// priority classes
+const (
+	// PriorityHigh 如操作员写入
+	PriorityHigh Priority = iota
+	// PriorityNormal 如报警轮询
+	PriorityNormal
+	// PriorityLow 如趋势轮询
+	PriorityLow
+	priorityCount
+)
+
+// QueueStats snapshot of a priority class queue metrics.
+type QueueStats struct {
+	Depth       int           // requests waiting in the queue
+	Requests    uint64        // requests sent to the bus
+	Rejected    uint64        // requests rejected since the queue is full
+	WaitTime    time.Duration // total time waited in the queue
+	MaxWaitTime time.Duration // the max time waited in the queue
+}
+
+// BusStats snapshot of the bus scheduler metrics.
+type BusStats struct {
+	Queues   map[Priority]QueueStats
+	MaxDepth int // high watermark of the requests waiting in all queues
+}
+
+// BusScheduler 共享总线的请求调度器, 多个goroutine共用一个provider时, 请求
+// 先按优先级类别调度, 高优先级的请求总是先发送, 同一类别内各从站轮流发送,
+// 总线上同时只有一个请求.
+type BusScheduler struct {
+	provider ClientProvider
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queues   [priorityCount]busQueue
+	depth    int // waiting in all queues
+	maxDepth int // 0 means unlimited
+	closed   bool
+	// metrics
+	highWater int
+}
+
+// busQueue 一个优先级类别的请求队列, 各从站一个FIFO, 从站轮流出队
+type busQueue struct {
+	slaves      map[byte][]*busRequest
+	order       []byte // slaves with waiting requests, round robin
+	requests    uint64
+	rejected    uint64
+	waitTime    time.Duration
+	maxWaitTime time.Duration
+	depth       int
+}
+
+type busRequest struct {
+	slaveID byte
+	do      func()
+	queued  time.Time
+	err     error // ErrSchedulerClosed if not sent
+	done    chan struct{}
+}
+
+// NewBusScheduler new bus scheduler in front of provider, 使用Provider获取
+// 各优先级类别的ClientProvider.
+func NewBusScheduler(provider ClientProvider) *BusScheduler {
+	sf := &BusScheduler{provider: provider}
+	sf.cond = sync.NewCond(&sf.mu)
+	go sf.run()
+	return sf
+}
+
+// SetMaxQueueDepth 设置所有队列等待请求的总数上限, 超过时请求立即返回
+// ErrQueueFull, n <= 0 不限制, 默认不限制.
+func (sf *BusScheduler) SetMaxQueueDepth(n int) *BusScheduler {
+	sf.mu.Lock()
+	sf.maxDepth = n
+	sf.mu.Unlock()
+	return sf
+}
+
+// Provider 返回以priority类别调度请求的ClientProvider, 可用于NewClient.
+// 它的Close不关闭下游provider, 由BusScheduler.Close关闭.
+func (sf *BusScheduler) Provider(priority Priority) ClientProvider {
+	if priority < PriorityHigh || priority >= priorityCount {
+		priority = PriorityLow
+	}
+	return &scheduledProvider{sf, priority}
+}
+
+// Close stop the scheduler, the waiting requests return ErrSchedulerClosed,
+// then close the downstream provider.
+func (sf *BusScheduler) Close() error {
+	sf.mu.Lock()
+	if sf.closed {
+		sf.mu.Unlock()
+		return nil
+	}
+	sf.closed = true
+	for i := range sf.queues {
+		for req := sf.queues[i].pop(); req != nil; req = sf.queues[i].pop() {
+			req.err = ErrSchedulerClosed
+			close(req.done)
+		}
+	}
+	sf.depth = 0
+	sf.cond.Broadcast()
+	sf.mu.Unlock()
+	return sf.provider.Close()
+}
+
+// Stats returns the snapshot of the bus scheduler metrics.
+func (sf *BusScheduler) Stats() BusStats {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	stats := BusStats{
+		Queues:   make(map[Priority]QueueStats, priorityCount),
+		MaxDepth: sf.highWater,
+	}
+	for i := range sf.queues {
+		q := &sf.queues[i]
+		stats.Queues[Priority(i)] = QueueStats{q.depth, q.requests, q.rejected, q.waitTime, q.maxWaitTime}
+	}
+	return stats
+}
+
+// submit 请求入队, 等待发送完成
+func (sf *BusScheduler) submit(priority Priority, slaveID byte, do func()) error {
+	req := &busRequest{slaveID: slaveID, do: do, queued: time.Now(), done: make(chan struct{})}
+	sf.mu.Lock()
+	if sf.closed {
+		sf.mu.Unlock()
+		return ErrSchedulerClosed
+	}
+	q := &sf.queues[priority]
+	if sf.maxDepth > 0 && sf.depth >= sf.maxDepth {
+		q.rejected++
+		sf.mu.Unlock()
+		return ErrQueueFull
+	}
+	q.push(req)
+	sf.depth++
+	if sf.depth > sf.highWater {
+		sf.highWater = sf.depth
+	}
+	sf.cond.Signal()
+	sf.mu.Unlock()
+
+	<-req.done
+	return req.err
+}
+
+// run 按优先级依次发送请求, 直到Close
+func (sf *BusScheduler) run() {
+	for {
+		req := sf.next()
+		if req == nil {
+			return
+		}
+		req.do()
+		close(req.done)
+	}
+}
+
+// next 等待下一个请求, 关闭后返回nil
+func (sf *BusScheduler) next() *busRequest {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for !sf.closed {
+		for i := range sf.queues {
+			q := &sf.queues[i]
+			if req := q.pop(); req != nil {
+				sf.depth--
+				wait := time.Since(req.queued)
+				q.requests++
+				q.waitTime += wait
+				if wait > q.maxWaitTime {
+					q.maxWaitTime = wait
+				}
+				return req
+			}
+		}
+		sf.cond.Wait()
+	}
+	return nil
+}
+
+func (sf *busQueue) push(req *busRequest) {
+	if sf.slaves == nil {
+		sf.slaves = make(map[byte][]*busRequest)
+	}
+	if len(sf.slaves[req.slaveID]) == 0 {
+		sf.order = append(sf.order, req.slaveID)
+	}
+	sf.slaves[req.slaveID] = append(sf.slaves[req.slaveID], req)
+	sf.depth++
+}
+
+// pop 取出轮到的从站的第一个请求, 该从站还有请求时排到最后
+func (sf *busQueue) pop() *busRequest {
+	if len(sf.order) == 0 {
+		return nil
+	}
+	slaveID := sf.order[0]
+	sf.order = sf.order[1:]
+	list := sf.slaves[slaveID]
+	req := list[0]
+	if len(list) > 1 {
+		sf.slaves[slaveID] = list[1:]
+		sf.order = append(sf.order, slaveID)
+	} else {
+		delete(sf.slaves, slaveID)
+	}
+	sf.depth--
+	return req
+}
+
+// scheduledProvider 以一个优先级类别经调度器访问下游provider
+type scheduledProvider struct {
+	scheduler *BusScheduler
+	priority  Priority
+}
+
+// check scheduledProvider implements the interface ClientProvider underlying method
+var _ ClientProvider = (*scheduledProvider)(nil)
+
+// Connect try to connect the remote server
+func (sf *scheduledProvider) Connect() error { return sf.scheduler.provider.Connect() }
+
+// IsConnected returns a bool signifying whether the client is connected or not.
+func (sf *scheduledProvider) IsConnected() bool { return sf.scheduler.provider.IsConnected() }
+
+// LogMode set enable or diable log output when you has set logger
+func (sf *scheduledProvider) LogMode(enable bool) { sf.scheduler.provider.LogMode(enable) }
+
+// Close does nothing, the downstream provider is closed by BusScheduler.Close.
+func (sf *scheduledProvider) Close() error { return nil }
+
+// Send request to the remote server through the scheduler
+func (sf *scheduledProvider) Send(slaveID byte, request ProtocolDataUnit) (response ProtocolDataUnit, err error) {
+	e := sf.scheduler.submit(sf.priority, slaveID, func() {
+		response, err = sf.scheduler.provider.Send(slaveID, request)
+	})
+	if e != nil {
+		return response, e
+	}
+	return response, err
+}
+
+// SendPdu send pdu request to the remote server through the scheduler
+func (sf *scheduledProvider) SendPdu(slaveID byte, pduRequest []byte) (pduResponse []byte, err error) {
+	e := sf.scheduler.submit(sf.priority, slaveID, func() {
+		pduResponse, err = sf.scheduler.provider.SendPdu(slaveID, pduRequest)
+	})
+	if e != nil {
+		return nil, e
+	}
+	return pduResponse, err
+}
+
+// SendRawFrame send raw frame to the remote server through the scheduler,
+// the slave id is taken from the frame, see rawFrameSlaveID.
+func (sf *scheduledProvider) SendRawFrame(aduRequest []byte) (aduResponse []byte, err error) {
+	slaveID := rawFrameSlaveID(sf.scheduler.provider, aduRequest)
+	e := sf.scheduler.submit(sf.priority, slaveID, func() {
+		aduResponse, err = sf.scheduler.provider.SendRawFrame(aduRequest)
+	})
+	if e != nil {
+		return nil, e
+	}
+	return aduResponse, err
+}
+
+// rawFrameSlaveID 返回provider的原始帧中的从站地址, tcp帧为MBAP头的unit id,
+// ascii帧为':'之后的两个十六进制字符, rtu帧为首字节, 取不到时为0.
+func rawFrameSlaveID(provider ClientProvider, adu []byte) byte {
+	switch provider.(type) {
+	case *TCPClientProvider:
+		if len(adu) >= tcpHeaderMbapSize {
+			return adu[tcpHeaderMbapSize-1]
+		}
+	case *ASCIIClientProvider:
+		if len(adu) >= 3 {
+			if b, err := hex.DecodeString(string(adu[1:3])); err == nil {
+				return b[0]
+			}
+		}
+	default:
+		if len(adu) > 0 {
+			return adu[0]
+		}
+	}
+	return 0
+}
+
+func (sf *scheduledProvider) setLogProvider(p LogProvider) {
+	sf.scheduler.provider.setLogProvider(p)
+}
+
+func (sf *scheduledProvider) setSerialConfig(config serial.Config) {
+	sf.scheduler.provider.setSerialConfig(config)
+}
+
+func (sf *scheduledProvider) setTCPTimeout(t time.Duration) {
+	sf.scheduler.provider.setTCPTimeout(t)
+}
+
+func (sf *scheduledProvider) setFrameSilence(t35 time.Duration) {
+	sf.scheduler.provider.setFrameSilence(t35)
+}
+
+func (sf *scheduledProvider) setTurnaroundDelay(t time.Duration) {
+	sf.scheduler.provider.setTurnaroundDelay(t)
+}
+
+func (sf *scheduledProvider) addCustomFunction(fns ...CustomFunction) {
+	sf.scheduler.provider.addCustomFunction(fns...)
+}
+
+func (sf *scheduledProvider) setCapture(sink CaptureSink) {
+	sf.scheduler.provider.setCapture(sink)
+}
+
+func (sf *scheduledProvider) setDialer(dial DialFunc) {
+	sf.scheduler.provider.setDialer(dial)
+}
+
+func (sf *scheduledProvider) setPortOpener(open PortOpenFunc) {
+	sf.scheduler.provider.setPortOpener(open)
+}
@@ -0,0 +1,101 @@
+package modbus
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBusScheduler(t *testing.T) {
+	downstream := &fakeProvider{release: make(chan struct{})}
+	sched := NewBusScheduler(downstream).SetMaxQueueDepth(4)
+	defer sched.Close()
+
+	var wg sync.WaitGroup
+	send := func(priority Priority, slaveID byte) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := sched.Provider(priority).SendPdu(slaveID, []byte{0x03}); err != nil {
+				t.Errorf("SendPdu() error = %v, wantErr %v", err, nil)
+			}
+		}()
+	}
+	waitDepth := func(depth int) {
+		for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+			n := 0
+			for _, q := range sched.Stats().Queues {
+				n += q.Depth
+			}
+			if n == depth {
+				return
+			}
+		}
+		t.Fatalf("queue depth never reached %d", depth)
+	}
+
+	send(PriorityLow, 9) // occupies the bus
+	for sched.Stats().Queues[PriorityLow].Requests == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	send(PriorityLow, 1)
+	waitDepth(1)
+	send(PriorityLow, 1)
+	waitDepth(2)
+	send(PriorityLow, 2)
+	waitDepth(3)
+	send(PriorityHigh, 3)
+	waitDepth(4)
+	if _, err := sched.Provider(PriorityNormal).SendPdu(4, []byte{0x03}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("SendPdu() error = %v, wantErr %v", err, ErrQueueFull)
+	}
+	close(downstream.release)
+	wg.Wait()
+
+	// high priority first, then slaves take turns
+	var order []byte
+	for _, r := range downstream.received() {
+		order = append(order, r.slaveID)
+	}
+	if want := []byte{9, 3, 1, 2, 1}; !reflect.DeepEqual(order, want) {
+		t.Errorf("bus order = %v, want %v", order, want)
+	}
+	stats := sched.Stats()
+	if stats.MaxDepth != 4 || stats.Queues[PriorityLow].Requests != 4 ||
+		stats.Queues[PriorityHigh].Requests != 1 || stats.Queues[PriorityNormal].Rejected != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+	if stats.Queues[PriorityLow].MaxWaitTime <= 0 {
+		t.Errorf("Stats() wait time not recorded")
+	}
+
+	sched.Close()
+	if _, err := sched.Provider(PriorityHigh).SendPdu(1, []byte{0x03}); !errors.Is(err, ErrSchedulerClosed) {
+		t.Errorf("SendPdu() error = %v, wantErr %v", err, ErrSchedulerClosed)
+	}
+}
+
+func Test_rawFrameSlaveID(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider ClientProvider
+		adu      []byte
+		want     byte
+	}{
+		{"tcp", NewTCPClientProvider("127.0.0.1:502"),
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x00, 0x00, 0x01}, 0x11},
+		{"tcp short", NewTCPClientProvider("127.0.0.1:502"), []byte{0x00, 0x01}, 0},
+		{"rtu", NewRTUClientProvider(), rtuFrame(0x11, 0x03, 0x00, 0x00, 0x00, 0x01), 0x11},
+		{"ascii", NewASCIIClientProvider(), []byte(":110300000001EB\r\n"), 0x11},
+		{"empty", NewRTUClientProvider(), nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rawFrameSlaveID(tt.provider, tt.adu); got != tt.want {
+				t.Errorf("rawFrameSlaveID() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
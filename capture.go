@@ -0,0 +1,48 @@
+package modbus
+
+import (
+	"net"
+	"time"
+)
+
+// CaptureSink 帧捕获接收者, 在收发帧的goroutine中调用, 实现需并发安全且不应阻塞太久.
+type CaptureSink interface {
+	Capture(frame CapturedFrame)
+}
+
+// CapturedFrame 捕获的一个adu
+type CapturedFrame struct {
+	Time       time.Time
+	Transport  Transport
+	Sent       bool     // sent by this side, otherwise received
+	LocalAddr  net.Addr // nil for serial
+	RemoteAddr net.Addr // nil for serial
+	ADU        []byte
+}
+
+// capturer 可选的帧捕获, 零值不捕获
+type capturer struct {
+	sink CaptureSink
+}
+
+// setCapture set capture sink, nil means disable.
+func (sf *capturer) setCapture(sink CaptureSink) {
+	sf.sink = sink
+}
+
+// capture 捕获一帧, 空帧忽略, conn为nil时没有地址
+func (sf *capturer) capture(transport Transport, sent bool, conn net.Conn, adu []byte) {
+	if sf.sink == nil || len(adu) == 0 {
+		return
+	}
+	frame := CapturedFrame{
+		Time:      time.Now(),
+		Transport: transport,
+		Sent:      sent,
+		ADU:       append([]byte(nil), adu...),
+	}
+	if conn != nil {
+		frame.LocalAddr, frame.RemoteAddr = conn.LocalAddr(), conn.RemoteAddr()
+	}
+	sf.sink.Capture(frame)
+}
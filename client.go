@@ -73,11 +73,9 @@ func (sf *client) ReadCoils(slaveID byte, address, quantity uint16) ([]byte, err
 	case err != nil:
 		return nil, err
 	case len(response.Data)-1 != int(response.Data[0]):
-		return nil, fmt.Errorf("modbus: response byte size '%v' does not match count '%v'",
-			len(response.Data)-1, int(response.Data[0]))
+		return nil, &LengthError{"response byte size", len(response.Data) - 1, int(response.Data[0])}
 	case uint16(response.Data[0]) != (quantity+7)/8:
-		return nil, fmt.Errorf("modbus: response byte size '%v' does not match quantity to bytes '%v'",
-			response.Data[0], (quantity+7)/8)
+		return nil, &LengthError{"response byte size", int(response.Data[0]), int((quantity + 7) / 8)}
 	}
 	return response.Data[1:], nil
 }
@@ -110,11 +108,9 @@ func (sf *client) ReadDiscreteInputs(slaveID byte, address, quantity uint16) ([]
 	case err != nil:
 		return nil, err
 	case len(response.Data)-1 != int(response.Data[0]):
-		return nil, fmt.Errorf("modbus: response byte size '%v' does not match count '%v'",
-			len(response.Data)-1, response.Data[0])
+		return nil, &LengthError{"response byte size", len(response.Data) - 1, int(response.Data[0])}
 	case uint16(response.Data[0]) != (quantity+7)/8:
-		return nil, fmt.Errorf("modbus: response byte size '%v' does not match quantity to bytes '%v'",
-			response.Data[0], (quantity+7)/8)
+		return nil, &LengthError{"response byte size", int(response.Data[0]), int((quantity + 7) / 8)}
 	}
 	return response.Data[1:], nil
 }
@@ -145,18 +141,17 @@ func (sf *client) WriteSingleCoil(slaveID byte, address uint16, isOn bool) error
 	switch {
 	case err != nil:
 		return err
+	case slaveID == AddressBroadCast && response.Data == nil: // no response for broadcast
+		return nil
 	case len(response.Data) != 4:
 		// Fixed response length
-		return fmt.Errorf("modbus: response data size '%v' does not match expected '%v'",
-			len(response.Data), 4)
+		return &LengthError{"response data size", len(response.Data), 4}
 	case binary.BigEndian.Uint16(response.Data) != address:
 		// check address
-		return fmt.Errorf("modbus: response address '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data), address)
+		return &ResponseError{"address", address, binary.BigEndian.Uint16(response.Data)}
 	case binary.BigEndian.Uint16(response.Data[2:]) != value:
 		// check value
-		return fmt.Errorf("modbus: response value '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data[2:]), value)
+		return &ResponseError{"value", value, binary.BigEndian.Uint16(response.Data[2:])}
 	}
 	return nil
 }
@@ -194,16 +189,15 @@ func (sf *client) WriteMultipleCoils(slaveID byte, address, quantity uint16, val
 	switch {
 	case err != nil:
 		return err
+	case slaveID == AddressBroadCast && response.Data == nil: // no response for broadcast
+		return nil
 	case len(response.Data) != 4:
 		// Fixed response length
-		return fmt.Errorf("modbus: response data size '%v' does not match expected '%v'",
-			len(response.Data), 4)
+		return &LengthError{"response data size", len(response.Data), 4}
 	case binary.BigEndian.Uint16(response.Data) != address:
-		return fmt.Errorf("modbus: response address '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data), address)
+		return &ResponseError{"address", address, binary.BigEndian.Uint16(response.Data)}
 	case binary.BigEndian.Uint16(response.Data[2:]) != quantity:
-		return fmt.Errorf("modbus: response quantity '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data[2:]), quantity)
+		return &ResponseError{"quantity", quantity, binary.BigEndian.Uint16(response.Data[2:])}
 	}
 	return nil
 }
@@ -237,11 +231,9 @@ func (sf *client) ReadInputRegistersBytes(slaveID byte, address, quantity uint16
 	case err != nil:
 		return nil, err
 	case len(response.Data)-1 != int(response.Data[0]):
-		return nil, fmt.Errorf("modbus: response data size '%v' does not match count '%v'",
-			len(response.Data)-1, response.Data[0])
+		return nil, &LengthError{"response data size", len(response.Data) - 1, int(response.Data[0])}
 	case uint16(response.Data[0]) != quantity*2:
-		return nil, fmt.Errorf("modbus: response data size '%v' does not match quantity to bytes '%v'",
-			response.Data[0], quantity*2)
+		return nil, &LengthError{"response data size", int(response.Data[0]), int(quantity * 2)}
 	}
 
 	return response.Data[1:], nil
@@ -291,11 +283,9 @@ func (sf *client) ReadHoldingRegistersBytes(slaveID byte, address, quantity uint
 	case err != nil:
 		return nil, err
 	case len(response.Data)-1 != int(response.Data[0]):
-		return nil, fmt.Errorf("modbus: response data size '%v' does not match count '%v'",
-			len(response.Data)-1, response.Data[0])
+		return nil, &LengthError{"response data size", len(response.Data) - 1, int(response.Data[0])}
 	case uint16(response.Data[0]) != quantity*2:
-		return nil, fmt.Errorf("modbus: response data size '%v' does not match quantity to bytes '%v'",
-			response.Data[0], quantity*2)
+		return nil, &LengthError{"response data size", int(response.Data[0]), int(quantity * 2)}
 	}
 	return response.Data[1:], nil
 }
@@ -339,16 +329,15 @@ func (sf *client) WriteSingleRegister(slaveID byte, address, value uint16) error
 	switch {
 	case err != nil:
 		return err
+	case slaveID == AddressBroadCast && response.Data == nil: // no response for broadcast
+		return nil
 	case len(response.Data) != 4:
 		// Fixed response length
-		return fmt.Errorf("modbus: response data size '%v' does not match expected '%v'",
-			len(response.Data), 4)
+		return &LengthError{"response data size", len(response.Data), 4}
 	case binary.BigEndian.Uint16(response.Data) != address:
-		return fmt.Errorf("modbus: response address '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data), address)
+		return &ResponseError{"address", address, binary.BigEndian.Uint16(response.Data)}
 	case binary.BigEndian.Uint16(response.Data[2:]) != value:
-		return fmt.Errorf("modbus: response value '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data[2:]), value)
+		return &ResponseError{"value", value, binary.BigEndian.Uint16(response.Data[2:])}
 	}
 	return nil
 }
@@ -386,16 +375,15 @@ func (sf *client) WriteMultipleRegistersBytes(slaveID byte, address, quantity ui
 	switch {
 	case err != nil:
 		return err
+	case slaveID == AddressBroadCast && response.Data == nil: // no response for broadcast
+		return nil
 	case len(response.Data) != 4:
 		// Fixed response length
-		return fmt.Errorf("modbus: response data size '%v' does not match expected '%v'",
-			len(response.Data), 4)
+		return &LengthError{"response data size", len(response.Data), 4}
 	case binary.BigEndian.Uint16(response.Data) != address:
-		return fmt.Errorf("modbus: response address '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data), address)
+		return &ResponseError{"address", address, binary.BigEndian.Uint16(response.Data)}
 	case binary.BigEndian.Uint16(response.Data[2:]) != quantity:
-		return fmt.Errorf("modbus: response quantity '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data[2:]), quantity)
+		return &ResponseError{"quantity", quantity, binary.BigEndian.Uint16(response.Data[2:])}
 	}
 	return nil
 }
@@ -439,19 +427,17 @@ func (sf *client) MaskWriteRegister(slaveID byte, address, andMask, orMask uint1
 	switch {
 	case err != nil:
 		return err
+	case slaveID == AddressBroadCast && response.Data == nil: // no response for broadcast
+		return nil
 	case len(response.Data) != 6:
 		// Fixed response length
-		return fmt.Errorf("modbus: response data size '%v' does not match expected '%v'",
-			len(response.Data), 6)
+		return &LengthError{"response data size", len(response.Data), 6}
 	case binary.BigEndian.Uint16(response.Data) != address:
-		return fmt.Errorf("modbus: response address '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data), address)
+		return &ResponseError{"address", address, binary.BigEndian.Uint16(response.Data)}
 	case binary.BigEndian.Uint16(response.Data[2:]) != andMask:
-		return fmt.Errorf("modbus: response AND-mask '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data[2:]), andMask)
+		return &ResponseError{"AND-mask", andMask, binary.BigEndian.Uint16(response.Data[2:])}
 	case binary.BigEndian.Uint16(response.Data[4:]) != orMask:
-		return fmt.Errorf("modbus: response OR-mask '%v' does not match request '%v'",
-			binary.BigEndian.Uint16(response.Data[4:]), orMask)
+		return &ResponseError{"OR-mask", orMask, binary.BigEndian.Uint16(response.Data[4:])}
 	}
 	return nil
 }
@@ -497,8 +483,7 @@ func (sf *client) ReadWriteMultipleRegistersBytes(slaveID byte, readAddress, rea
 		return nil, err
 	}
 	if int(response.Data[0]) != (len(response.Data) - 1) {
-		return nil, fmt.Errorf("modbus: response data size '%v' does not match count '%v'",
-			len(response.Data)-1, response.Data[0])
+		return nil, &LengthError{"response data size", len(response.Data) - 1, int(response.Data[0])}
 	}
 	return response.Data[1:], nil
 }
@@ -548,18 +533,47 @@ func (sf *client) ReadFIFOQueue(slaveID byte, address uint16) ([]byte, error) {
 	case err != nil:
 		return nil, err
 	case len(response.Data) < 4:
-		return nil, fmt.Errorf("modbus: response data size '%v' is less than expected '%v'",
+		return nil, frameErrorf("response data size '%v' is less than expected '%v'",
 			len(response.Data), 4)
 	case len(response.Data)-2 != int(binary.BigEndian.Uint16(response.Data)):
-		return nil, fmt.Errorf("modbus: response data size '%v' does not match count '%v'",
-			len(response.Data)-2, binary.BigEndian.Uint16(response.Data))
+		return nil, &LengthError{"response data size", len(response.Data) - 2, int(binary.BigEndian.Uint16(response.Data))}
 	case int(binary.BigEndian.Uint16(response.Data[2:])) > 31:
-		return nil, fmt.Errorf("modbus: fifo count '%v' is greater than expected '%v'",
+		return nil, frameErrorf("fifo count '%v' is greater than expected '%v'",
 			binary.BigEndian.Uint16(response.Data[2:]), 31)
 	}
 	return response.Data[4:], nil
 }
 
+// Request:
+//  Slave Id              : 1 byte
+//  Function code         : 1 byte (user defined)
+//  Data                  : N* bytes (request.MarshalData)
+// Response:
+//  Function code         : 1 byte (user defined)
+//  Data                  : N* bytes (response.UnmarshalData)
+func (sf *client) Invoke(slaveID byte, request CustomRequest, response CustomResponse) error {
+	if slaveID > sf.addressMax {
+		return fmt.Errorf("modbus: slaveID '%v' must be between '%v' and '%v'",
+			slaveID, AddressBroadCast, sf.addressMax)
+	}
+	data, err := request.MarshalData()
+	if err != nil {
+		return err
+	}
+	rsp, err := sf.Send(slaveID, ProtocolDataUnit{
+		FuncCode: request.FuncCode(),
+		Data:     data,
+	})
+	switch {
+	case err != nil:
+		return err
+	case response == nil,
+		slaveID == AddressBroadCast && rsp.Data == nil: // no response for broadcast
+		return nil
+	}
+	return response.UnmarshalData(rsp.Data)
+}
+
 // uint162Bytes creates a sequence of uint16 data.
 func uint162Bytes(value ...uint16) []byte {
 	data := make([]byte, 2*len(value))
@@ -15,6 +15,8 @@ const (
 // ASCIIClientProvider implements ClientProvider interface.
 type ASCIIClientProvider struct {
 	serialPort
+	functionRegistry
+	capturer
 	logger
 	*pool
 }
@@ -29,8 +31,9 @@ var asciiPool = newPool(asciiCharacterMaxSize)
 // it will use default /dev/ttyS0 19200 8 1 N and timeout 1000.
 func NewASCIIClientProvider(opts ...ClientProviderOption) *ASCIIClientProvider {
 	p := &ASCIIClientProvider{
-		logger: newLogger("modbusASCIIMaster => "),
-		pool:   asciiPool,
+		serialPort: serialPort{turnaroundDelay: SerialDefaultTurnaroundDelay},
+		logger:     newLogger("modbusASCIIMaster => "),
+		pool:       asciiPool,
 	}
 	for _, opt := range opts {
 		opt(p)
@@ -78,16 +81,16 @@ func (sf *protocolFrame) encodeASCIIFrame(slaveID byte, pdu ProtocolDataUnit) ([
 // decode extracts slaveID & PDU from ASCII frame and verify LRC.
 func decodeASCIIFrame(adu []byte) (uint8, []byte, error) {
 	if len(adu) < asciiAduMinSize+6 { // Minimum size (including address, function and LRC)
-		return 0, nil, fmt.Errorf("modbus: response length '%v' does not meet minimum '%v'", len(adu), 9)
+		return 0, nil, frameErrorf("response length '%v' does not meet minimum '%v'", len(adu), 9)
 	}
 	switch {
 	case len(adu)%2 != 1: // Length excluding colon must be an even number
-		return 0, nil, fmt.Errorf("modbus: response length '%v' is not an even number", len(adu)-1)
+		return 0, nil, frameErrorf("response length '%v' is not an even number", len(adu)-1)
 	case string(adu[0:len(asciiStart)]) != asciiStart: // First char must be a colons
-		return 0, nil, fmt.Errorf("modbus: response frame '%x'... is not started with '%x'",
+		return 0, nil, frameErrorf("response frame '%x'... is not started with '%x'",
 			string(adu[0:len(asciiStart)]), asciiStart)
 	case string(adu[len(adu)-len(asciiEnd):]) != asciiEnd: // 2 last chars must be \r\n
-		return 0, nil, fmt.Errorf("modbus: response frame ...'%x' is not ended with '%x'",
+		return 0, nil, frameErrorf("response frame ...'%x' is not ended with '%x'",
 			string(adu[len(adu)-len(asciiEnd):]), asciiEnd)
 	}
 
@@ -96,12 +99,12 @@ func decodeASCIIFrame(adu []byte) (uint8, []byte, error) {
 	buf := make([]byte, hex.DecodedLen(len(dat)))
 	length, err := hex.Decode(buf, dat)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, frameErrorf("response frame is not hex encoded, %v", err)
 	}
 	// Calculate checksum
 	lrcVal := new(LRC).Reset().Push(buf[:length-1]...).Value()
 	if buf[length-1] != lrcVal { // LRC
-		return 0, nil, fmt.Errorf("modbus: response lrc '%x' does not match expected '%x'", buf[length-1], lrcVal)
+		return 0, nil, &LRCError{lrcVal, buf[length-1]}
 	}
 	return buf[0], buf[1 : length-1], nil
 }
@@ -118,7 +121,7 @@ func (sf *ASCIIClientProvider) Send(slaveID byte, request ProtocolDataUnit) (Pro
 		return response, err
 	}
 	aduResponse, err := sf.SendRawFrame(aduRequest)
-	if err != nil {
+	if err != nil || slaveID == AddressBroadCast {
 		return response, err
 	}
 	rspSlaveID, pdu, err := decodeASCIIFrame(aduResponse)
@@ -130,7 +133,8 @@ func (sf *ASCIIClientProvider) Send(slaveID byte, request ProtocolDataUnit) (Pro
 	if err = verify(slaveID, rspSlaveID, request, response); err != nil {
 		return response, err
 	}
-	return response, nil
+	err = sf.verifyResponseLength(request, response)
+	return response, err
 }
 
 // SendPdu send pdu request to the remote server.
@@ -149,7 +153,7 @@ func (sf *ASCIIClientProvider) SendPdu(slaveID byte, pduRequest []byte) ([]byte,
 		return nil, err
 	}
 	aduResponse, err := sf.SendRawFrame(aduRequest)
-	if err != nil {
+	if err != nil || slaveID == AddressBroadCast {
 		return nil, err
 	}
 	rspSlaveID, pdu, err := decodeASCIIFrame(aduResponse)
@@ -160,6 +164,9 @@ func (sf *ASCIIClientProvider) SendPdu(slaveID byte, pduRequest []byte) ([]byte,
 	if err = verify(slaveID, rspSlaveID, request, response); err != nil {
 		return nil, err
 	}
+	if err = sf.verifyResponseLength(request, response); err != nil {
+		return nil, err
+	}
 	return pdu, nil
 }
 
@@ -168,16 +175,34 @@ func (sf *ASCIIClientProvider) SendRawFrame(aduRequest []byte) (aduResponse []by
 	sf.mu.Lock()
 	defer sf.mu.Unlock()
 
+	// slave id and function code are the first 4 chars after colon
+	var head [2]byte
+	broadcast := false
+	if len(aduRequest) >= 5 {
+		if _, err = hex.Decode(head[:], aduRequest[1:5]); err == nil && head[0] == AddressBroadCast {
+			if err = sf.verifyBroadcast(head[1]); err != nil {
+				return nil, err
+			}
+			broadcast = true
+		}
+	}
 	if err = sf.connect(); err != nil {
 		return nil, err
 	}
+	sf.waitTurnaround()
 	// Send the request
 	sf.Debugf("sending [% x]", aduRequest)
 
 	_, err = sf.port.Write(aduRequest)
-	if err == nil {
+	if err != nil {
 		sf.close()
-		return
+		return nil, wrapTimeout(err)
+	}
+	sf.capture(TransportASCII, true, nil, aduRequest)
+	defer func() { sf.capture(TransportASCII, false, nil, aduResponse) }()
+	if broadcast { // no response for broadcast
+		sf.broadcastSent()
+		return nil, nil
 	}
 
 	// Get the response
@@ -186,7 +211,7 @@ func (sf *ASCIIClientProvider) SendRawFrame(aduRequest []byte) (aduResponse []by
 	length := 0
 	for {
 		if n, err = sf.port.Read(data[length:]); err != nil {
-			return
+			return nil, wrapTimeout(err)
 		}
 		length += n
 		if length >= asciiCharacterMaxSize || n == 0 {
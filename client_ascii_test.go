@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"bufio"
 	"reflect"
 	"testing"
 )
@@ -119,3 +120,32 @@ func BenchmarkASCIIClientProvider_decodeASCIIFrame(b *testing.B) {
 		}
 	}
 }
+
+func TestASCIIClientProvider_keepConnection(t *testing.T) {
+	port := newPipePort()
+	defer port.Close()
+	go func() {
+		r := bufio.NewReader(port.remote)
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			if _, err := port.remote.Write([]byte(":010302123AAE\r\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	p := NewASCIIClientProvider()
+	p.port = port
+	client := NewClient(p)
+	for i := 0; i < 2; i++ { // the port is kept open after a successful request
+		got, err := client.ReadHoldingRegisters(0x01, 0, 1)
+		if err != nil || !reflect.DeepEqual(got, []uint16{0x123a}) {
+			t.Fatalf("request %d ReadHoldingRegisters() = %v, %v, want %v", i, got, err, []uint16{0x123a})
+		}
+		if !p.IsConnected() {
+			t.Fatalf("request %d closed the port", i)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// ResponseLengthFunc returns the length of the response pdu data(exclude
+// function code) by the request pdu data and the response pdu data received
+// so far, it returns -1 if the received data is not enough to decide.
+type ResponseLengthFunc func(request, response []byte) int
+
+// FixedResponseLength the response pdu data has fixed length n.
+func FixedResponseLength(n int) ResponseLengthFunc {
+	return func([]byte, []byte) int { return n }
+}
+
+// ByteCountResponseLength the response pdu data carries a byte count of
+// size(1 or 2) bytes at offset, and followed by byte count bytes.
+func ByteCountResponseLength(offset, size int) ResponseLengthFunc {
+	return func(_, response []byte) int {
+		if len(response) < offset+size {
+			return -1
+		}
+		count := int(response[offset])
+		if size == 2 {
+			count = int(binary.BigEndian.Uint16(response[offset:]))
+		}
+		return offset + size + count
+	}
+}
+
+// CustomFunction declares a user defined function on client side, serial
+// framing use it to know the length of the response.
+type CustomFunction struct {
+	FuncCode       byte
+	ResponseLength ResponseLengthFunc
+	// Broadcast the function can be addressed to the broadcast slave id.
+	Broadcast bool
+}
+
+// CustomRequest typed request of the user defined function.
+type CustomRequest interface {
+	// FuncCode returns the function code of the request.
+	FuncCode() byte
+	// MarshalData encodes the request pdu data, exclude function code.
+	MarshalData() ([]byte, error)
+}
+
+// CustomResponse typed response of the user defined function.
+type CustomResponse interface {
+	// UnmarshalData decodes the response pdu data, exclude function code.
+	UnmarshalData(data []byte) error
+}
+
+// functionRegistry user defined functions of a client provider.
+type functionRegistry struct {
+	rw        sync.RWMutex
+	functions map[byte]CustomFunction
+}
+
+func (sf *functionRegistry) addCustomFunction(fns ...CustomFunction) {
+	sf.rw.Lock()
+	if sf.functions == nil {
+		sf.functions = make(map[byte]CustomFunction)
+	}
+	for _, fn := range fns {
+		sf.functions[fn.FuncCode] = fn
+	}
+	sf.rw.Unlock()
+}
+
+// customFunction returns the declaration of the function code.
+func (sf *functionRegistry) customFunction(funcCode byte) (CustomFunction, bool) {
+	sf.rw.RLock()
+	fn, ok := sf.functions[funcCode]
+	sf.rw.RUnlock()
+	return fn, ok
+}
+
+// verifyBroadcast check the function can be broadcast.
+func (sf *functionRegistry) verifyBroadcast(funcCode byte) error {
+	if fn, ok := sf.customFunction(funcCode); ok && fn.Broadcast {
+		return nil
+	}
+	return verifyBroadcast(funcCode)
+}
+
+// verifyResponseLength confirms the response pdu data length of the user defined function.
+func (sf *functionRegistry) verifyResponseLength(request, response ProtocolDataUnit) error {
+	fn, ok := sf.customFunction(request.FuncCode)
+	if !ok || fn.ResponseLength == nil || response.FuncCode != request.FuncCode {
+		return nil
+	}
+	if length := fn.ResponseLength(request.Data, response.Data); length != len(response.Data) {
+		return &LengthError{"response data size", len(response.Data), length}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package modbus
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestByteCountResponseLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		offset   int
+		size     int
+		response []byte
+		want     int
+	}{
+		{"not enough", 0, 1, nil, -1},
+		{"1 byte count", 0, 1, []byte{0x04}, 5},
+		{"2 bytes count not enough", 0, 2, []byte{0x00}, -1},
+		{"2 bytes count", 0, 2, []byte{0x00, 0x06}, 8},
+		{"offset", 2, 1, []byte{0x00, 0x01, 0x02}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ByteCountResponseLength(tt.offset, tt.size)(nil, tt.response); got != tt.want {
+				t.Errorf("ByteCountResponseLength() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type testCustomRequest struct{ param byte }
+
+func (r testCustomRequest) FuncCode() byte               { return 0x41 }
+func (r testCustomRequest) MarshalData() ([]byte, error) { return []byte{r.param}, nil }
+
+type testCustomResponse struct{ value []byte }
+
+func (r *testCustomResponse) UnmarshalData(data []byte) error {
+	if len(data) < 1 || int(data[0]) != len(data)-1 {
+		return errors.New("invalid response")
+	}
+	r.value = data[1:]
+	return nil
+}
+
+func TestRTUClientProvider_customFunction(t *testing.T) {
+	port := newPipePort()
+	defer port.Close()
+
+	p := NewRTUClientProvider(WithCustomFunction(CustomFunction{
+		FuncCode:       0x41,
+		ResponseLength: ByteCountResponseLength(0, 1),
+	}))
+	p.port = port
+	go func() {
+		req := make([]byte, 5)
+		if _, err := io.ReadFull(port.remote, req); err != nil {
+			return
+		}
+		// byte count arrives later than the minimum size
+		_, _ = port.remote.Write([]byte{0x01, 0x41, 0x04, 0xde})
+		time.Sleep(5 * time.Millisecond)
+		_, _ = port.remote.Write([]byte{0xad, 0xbe, 0xef, 0x6e, 0xf4})
+	}()
+
+	rsp := &testCustomResponse{}
+	if err := NewClient(p).Invoke(0x01, testCustomRequest{0x07}, rsp); err != nil {
+		t.Fatalf("Invoke() error = %v, wantErr %v", err, nil)
+	}
+	if want := []byte{0xde, 0xad, 0xbe, 0xef}; !reflect.DeepEqual(rsp.value, want) {
+		t.Errorf("Invoke() response = % x, want % x", rsp.value, want)
+	}
+}
+
+func Test_functionRegistry(t *testing.T) {
+	var r functionRegistry
+	r.addCustomFunction(
+		CustomFunction{FuncCode: 0x41, ResponseLength: FixedResponseLength(2)},
+		CustomFunction{FuncCode: 0x42, Broadcast: true},
+	)
+
+	if err := r.verifyBroadcast(0x41); !errors.Is(err, ErrBroadcastRead) {
+		t.Errorf("verifyBroadcast(0x41) error = %v, wantErr %v", err, ErrBroadcastRead)
+	}
+	if err := r.verifyBroadcast(0x42); err != nil {
+		t.Errorf("verifyBroadcast(0x42) error = %v, wantErr %v", err, nil)
+	}
+	err := r.verifyResponseLength(ProtocolDataUnit{0x41, nil}, ProtocolDataUnit{0x41, []byte{1, 2, 3}})
+	if !errors.Is(err, ErrLengthMismatch) {
+		t.Errorf("verifyResponseLength() error = %v, wantErr %v", err, ErrLengthMismatch)
+	}
+	err = r.verifyResponseLength(ProtocolDataUnit{0x41, nil}, ProtocolDataUnit{0x41, []byte{1, 2}})
+	if err != nil {
+		t.Errorf("verifyResponseLength() error = %v, wantErr %v", err, nil)
+	}
+}
@@ -1,6 +1,8 @@
 package modbus
 
 import (
+	"io"
+	"net"
 	"time"
 
 	"github.com/goburrow/serial"
@@ -9,6 +11,12 @@ import (
 // ClientProviderOption client provider option for user.
 type ClientProviderOption func(ClientProvider)
 
+// DialFunc dial the tcp connection, such as (&net.Dialer{}).Dial.
+type DialFunc func(network, address string) (net.Conn, error)
+
+// PortOpenFunc open the serial port of the config, such as serial.Open.
+type PortOpenFunc func(config *serial.Config) (io.ReadWriteCloser, error)
+
 // WithLogProvider set logger provider.
 func WithLogProvider(provider LogProvider) ClientProviderOption {
 	return func(p ClientProvider) {
@@ -36,3 +44,50 @@ func WithTCPTimeout(t time.Duration) ClientProviderOption {
 		p.setTCPTimeout(t)
 	}
 }
+
+// WithTurnaroundDelay set the delay after broadcast before the next request,
+// only valid on serial.
+func WithTurnaroundDelay(t time.Duration) ClientProviderOption {
+	return func(p ClientProvider) {
+		p.setTurnaroundDelay(t)
+	}
+}
+
+// WithCustomFunction declare user defined functions, only valid on serial.
+func WithCustomFunction(fns ...CustomFunction) ClientProviderOption {
+	return func(p ClientProvider) {
+		p.addCustomFunction(fns...)
+	}
+}
+
+// WithFrameSilence enable frame reception by the t3.5 inter-frame silence
+// instead of the predicted response length, only valid on RTU.
+// t35 <= 0 means derive it from the baud rate.
+func WithFrameSilence(t35 time.Duration) ClientProviderOption {
+	return func(p ClientProvider) {
+		p.setFrameSilence(t35)
+	}
+}
+
+// WithCapture capture every sent and received frame to the sink, such as PcapWriter.
+func WithCapture(sink CaptureSink) ClientProviderOption {
+	return func(p ClientProvider) {
+		p.setCapture(sink)
+	}
+}
+
+// WithDialer dial the connection by dial instead of the net.Dialer with the
+// tcp timeout, such as an in memory connection, only valid on TCP.
+func WithDialer(dial DialFunc) ClientProviderOption {
+	return func(p ClientProvider) {
+		p.setDialer(dial)
+	}
+}
+
+// WithPortOpener open the port by open instead of serial.Open, such as an
+// in memory port, only valid on serial.
+func WithPortOpener(open PortOpenFunc) ClientProviderOption {
+	return func(p ClientProvider) {
+		p.setPortOpener(open)
+	}
+}
@@ -0,0 +1,170 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// RecordedExchange 录制的一次请求应答
+type RecordedExchange struct {
+	Time     time.Time
+	SlaveID  byte
+	Request  []byte // request pdu
+	Response []byte // response pdu(may be exception), nil if no response
+	Err      string // why no response, such as timeout
+}
+
+// recordLine 录制文件一行的json格式, pdu以十六进制表示便于阅读
+type recordLine struct {
+	Time     time.Time `json:"time"`
+	SlaveID  byte      `json:"slave_id"`
+	Request  string    `json:"request"`
+	Response string    `json:"response,omitempty"`
+	Err      string    `json:"err,omitempty"`
+}
+
+// ReadRecording read the exchanges recorded by Recorder, one json object per line.
+func ReadRecording(r io.Reader) ([]RecordedExchange, error) {
+	var exchanges []RecordedExchange
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var line recordLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, err
+		}
+		request, err := hex.DecodeString(line.Request)
+		if err != nil {
+			return nil, err
+		}
+		if len(request) == 0 {
+			return nil, errors.New("modbus: recorded request is empty")
+		}
+		var response []byte
+		if line.Response != "" {
+			if response, err = hex.DecodeString(line.Response); err != nil {
+				return nil, err
+			}
+		}
+		exchanges = append(exchanges, RecordedExchange{line.Time, line.SlaveID, request, response, line.Err})
+	}
+	return exchanges, scanner.Err()
+}
+
+// LoadRecording read the named recording file, see ReadRecording.
+func LoadRecording(name string) ([]RecordedExchange, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRecording(f)
+}
+
+// Recorder 录制经过provider的每次请求应答, 每次一行json写入, 用于ReplayServer回放.
+// 它本身是ClientProvider, 可用于NewClient. SendRawFrame与传输层相关, 不录制.
+type Recorder struct {
+	ClientProvider
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// check Recorder implements the interface ClientProvider underlying method
+var _ ClientProvider = (*Recorder)(nil)
+
+// NewRecorder new recorder of provider, writes the exchanges to w.
+func NewRecorder(provider ClientProvider, w io.Writer) *Recorder {
+	return &Recorder{ClientProvider: provider, w: w}
+}
+
+// CreateRecordingFile create the named recording file, see NewRecorder.
+func CreateRecordingFile(provider ClientProvider, name string) (*Recorder, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(provider, f), nil
+}
+
+// Send request to the remote server and record it
+func (sf *Recorder) Send(slaveID byte, request ProtocolDataUnit) (ProtocolDataUnit, error) {
+	start := time.Now()
+	response, err := sf.ClientProvider.Send(slaveID, request)
+	var rspPdu []byte
+	if response.FuncCode != 0 || response.Data != nil { // broadcast has no response
+		rspPdu = append([]byte{response.FuncCode}, response.Data...)
+	}
+	sf.record(start, slaveID, append([]byte{request.FuncCode}, request.Data...), rspPdu, err)
+	return response, err
+}
+
+// SendPdu send pdu request to the remote server and record it
+func (sf *Recorder) SendPdu(slaveID byte, pduRequest []byte) ([]byte, error) {
+	start := time.Now()
+	pduResponse, err := sf.ClientProvider.SendPdu(slaveID, pduRequest)
+	sf.record(start, slaveID, pduRequest, pduResponse, err)
+	return pduResponse, err
+}
+
+// Err returns the first write error, the recorder stops recording after it.
+func (sf *Recorder) Err() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.err
+}
+
+// Close close the provider, and the writer if it is an io.Closer.
+func (sf *Recorder) Close() error {
+	err := sf.ClientProvider.Close()
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.err == nil {
+		sf.err = errors.New("modbus: recorder closed")
+	}
+	if c, ok := sf.w.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// record 写入一次请求应答, 异常记为异常应答, 其它错误时没有应答
+func (sf *Recorder) record(start time.Time, slaveID byte, request, response []byte, err error) {
+	if len(request) == 0 {
+		return
+	}
+	var e *ExceptionError
+	if errors.As(err, &e) {
+		response, err = []byte{request[0] | 0x80, e.ExceptionCode}, nil
+	} else if err != nil {
+		response = nil
+	}
+	line := recordLine{
+		Time:     start,
+		SlaveID:  slaveID,
+		Request:  hex.EncodeToString(request),
+		Response: hex.EncodeToString(response),
+	}
+	if err != nil {
+		line.Err = err.Error()
+	}
+	b, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.err == nil {
+		_, sf.err = sf.w.Write(append(b, '\n'))
+	}
+}
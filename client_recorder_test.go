@@ -0,0 +1,108 @@
+package modbus
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	srv := NewTCPServer()
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	recorder := NewRecorder(NewTCPClientProvider(addr), &buf)
+	client := NewClient(recorder)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.WriteSingleRegister(0x01, 1, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadHoldingRegisters(0x01, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadHoldingRegisters(0x01, 20, 1); err == nil {
+		t.Errorf("ReadHoldingRegisters() error = %v, wantErr %v", err, true)
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	exchanges, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RecordedExchange{
+		{SlaveID: 0x01, Request: []byte{0x06, 0x00, 0x01, 0x12, 0x34}, Response: []byte{0x06, 0x00, 0x01, 0x12, 0x34}},
+		{SlaveID: 0x01, Request: []byte{0x03, 0x00, 0x00, 0x00, 0x02}, Response: []byte{0x03, 0x04, 0x00, 0x00, 0x12, 0x34}},
+		{SlaveID: 0x01, Request: []byte{0x03, 0x00, 0x14, 0x00, 0x01}, Response: []byte{0x83, 0x02}},
+	}
+	if len(exchanges) != len(want) {
+		t.Fatalf("ReadRecording() got %d exchanges, want %d", len(exchanges), len(want))
+	}
+	for i := range want {
+		if exchanges[i].Time.IsZero() {
+			t.Errorf("exchange %d time is zero", i)
+		}
+		exchanges[i].Time = want[i].Time
+		if !reflect.DeepEqual(exchanges[i], want[i]) {
+			t.Errorf("exchange %d = %+v, want %+v", i, exchanges[i], want[i])
+		}
+	}
+}
+
+func TestRecorder_broadcast(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&provider{}, &buf) // broadcast returns no response
+	if err := NewClient(recorder).WriteSingleCoil(AddressBroadCast, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	exchanges, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != 1 || exchanges[0].Response != nil {
+		t.Fatalf("ReadRecording() = %+v, want a broadcast without response", exchanges)
+	}
+
+	// the broadcast is not answered on replay, the next response is of the next request
+	srv := NewReplayServer(exchanges)
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(listen) }()
+	defer srv.Close()
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x00, 0x05, 0x00, 0x01, 0xff, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	got := exchangeTCP(t, conn, []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01})
+	if want := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, 0x04}; !reflect.DeepEqual(got, want) {
+		t.Errorf("response = % x, want % x", got, want)
+	}
+}
+
+func TestReadRecording(t *testing.T) {
+	exchanges, err := ReadRecording(bytes.NewBufferString(
+		`{"time":"2020-01-02T03:04:05Z","slave_id":2,"request":"0300000001","err":"timeout"}` + "\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != 1 || exchanges[0].SlaveID != 2 || exchanges[0].Response != nil || exchanges[0].Err != "timeout" {
+		t.Errorf("ReadRecording() = %+v", exchanges)
+	}
+	for _, s := range []string{`{"slave_id":1}`, `{"slave_id":1,"request":"zz"}`, `not json`} {
+		if _, err := ReadRecording(bytes.NewBufferString(s)); err == nil {
+			t.Errorf("ReadRecording(%s) error = %v, wantErr %v", s, err, true)
+		}
+	}
+}
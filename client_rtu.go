@@ -14,8 +14,14 @@ const (
 // RTUClientProvider implements ClientProvider interface.
 type RTUClientProvider struct {
 	serialPort
+	functionRegistry
+	capturer
 	logger
 	*pool
+	// silence based frame reception
+	enableSilence bool
+	t35           time.Duration
+	receiver      *frameReceiver
 }
 
 // check RTUClientProvider implements the interface ClientProvider underlying method
@@ -28,8 +34,9 @@ var rtuPool = newPool(rtuAduMaxSize)
 // it will use default /dev/ttyS0 19200 8 1 N and timeout 1000
 func NewRTUClientProvider(opts ...ClientProviderOption) *RTUClientProvider {
 	p := &RTUClientProvider{
-		logger: newLogger("modbusRTUMaster => "),
-		pool:   rtuPool,
+		serialPort: serialPort{turnaroundDelay: SerialDefaultTurnaroundDelay},
+		logger:     newLogger("modbusRTUMaster => "),
+		pool:       rtuPool,
 	}
 	for _, opt := range opts {
 		opt(p)
@@ -53,12 +60,12 @@ func (sf *protocolFrame) encodeRTUFrame(slaveID byte, pdu ProtocolDataUnit) ([]b
 // decode extracts slaveID and PDU from RTU frame and verify CRC.
 func decodeRTUFrame(adu []byte) (uint8, []byte, error) {
 	if len(adu) < rtuAduMinSize { // Minimum size (including address, funcCode and CRC)
-		return 0, nil, fmt.Errorf("modbus: response length '%v' does not meet minimum '%v'", len(adu), rtuAduMinSize)
+		return 0, nil, frameErrorf("response length '%v' does not meet minimum '%v'", len(adu), rtuAduMinSize)
 	}
 	// Calculate checksum
 	crc, expect := CRC16(adu[:len(adu)-2]), binary.LittleEndian.Uint16(adu[len(adu)-2:])
 	if crc != expect {
-		return 0, nil, fmt.Errorf("modbus: response crc '%x' does not match expected '%x'", expect, crc)
+		return 0, nil, &CRCError{crc, expect}
 	}
 	// slaveID & PDU but pass crc
 	return adu[0], adu[1 : len(adu)-2], nil
@@ -76,7 +83,7 @@ func (sf *RTUClientProvider) Send(slaveID byte, request ProtocolDataUnit) (Proto
 		return response, err
 	}
 	aduResponse, err := sf.SendRawFrame(aduRequest)
-	if err != nil {
+	if err != nil || slaveID == AddressBroadCast {
 		return response, err
 	}
 	rspSlaveID, pdu, err := decodeRTUFrame(aduResponse)
@@ -84,7 +91,10 @@ func (sf *RTUClientProvider) Send(slaveID byte, request ProtocolDataUnit) (Proto
 		return response, err
 	}
 	response = ProtocolDataUnit{pdu[0], pdu[1:]}
-	err = verify(slaveID, rspSlaveID, request, response)
+	if err = verify(slaveID, rspSlaveID, request, response); err != nil {
+		return response, err
+	}
+	err = sf.verifyResponseLength(request, response)
 	return response, err
 }
 
@@ -104,7 +114,7 @@ func (sf *RTUClientProvider) SendPdu(slaveID byte, pduRequest []byte) ([]byte, e
 		return nil, err
 	}
 	aduResponse, err := sf.SendRawFrame(requestAdu)
-	if err != nil {
+	if err != nil || slaveID == AddressBroadCast {
 		return nil, err
 	}
 	rspSlaveID, pdu, err := decodeRTUFrame(aduResponse)
@@ -115,6 +125,9 @@ func (sf *RTUClientProvider) SendPdu(slaveID byte, pduRequest []byte) ([]byte, e
 	if err = verify(slaveID, rspSlaveID, request, response); err != nil {
 		return nil, err
 	}
+	if err = sf.verifyResponseLength(request, response); err != nil {
+		return nil, err
+	}
 	//  PDU pass slaveID & crc
 	return pdu, nil
 }
@@ -124,20 +137,53 @@ func (sf *RTUClientProvider) SendRawFrame(aduRequest []byte) (aduResponse []byte
 	sf.mu.Lock()
 	defer sf.mu.Unlock()
 
+	broadcast := aduRequest[0] == AddressBroadCast
+	if broadcast {
+		if err = sf.verifyBroadcast(aduRequest[1]); err != nil {
+			return
+		}
+	}
 	if err = sf.connect(); err != nil {
 		return
 	}
+	sf.waitTurnaround()
+	if sf.enableSilence {
+		if sf.receiver == nil || sf.receiver.port != sf.port || sf.receiver.stopped() {
+			sf.receiver = newFrameReceiver(sf.port, sf.frameSilence())
+		}
+		// discard the garbage before request
+		sf.receiver.flush()
+		if n := sf.receiver.takeDropped(); n > 0 {
+			sf.Debugf("receive buffer full, dropped %d bytes", n)
+		}
+	}
 
 	// Send the request
 	sf.Debugf("sending [% x]", aduRequest)
 	_, err = sf.port.Write(aduRequest)
 	if err != nil {
 		sf.close()
-		return
+		return nil, wrapTimeout(err)
+	}
+	sf.capture(TransportRTU, true, nil, aduRequest)
+	defer func() { sf.capture(TransportRTU, false, nil, aduResponse) }()
+	if broadcast { // no response for broadcast
+		sf.broadcastSent()
+		return nil, nil
+	}
+
+	if sf.enableSilence {
+		return sf.receive()
 	}
 
 	function, functionFail := aduRequest[1], aduRequest[1]|0x80
+	custom, isCustom := sf.customFunction(function)
 	bytesToRead := calculateResponseLength(aduRequest)
+	if isCustom && custom.ResponseLength != nil {
+		if length := custom.ResponseLength(aduRequest[2:len(aduRequest)-2], nil); length >= 0 {
+			bytesToRead = length + rtuAduMinSize
+		}
+	}
 	time.Sleep(sf.calculateDelay(len(aduRequest) + bytesToRead))
 
 	var n int
@@ -147,10 +193,12 @@ func (sf *RTUClientProvider) SendRawFrame(aduRequest []byte) (aduResponse []byte
 	// or the error package, depending on the error status (byte 2 of the response)
 	n, err = io.ReadAtLeast(sf.port, data[:], rtuAduMinSize)
 	if err != nil {
-		return
+		return nil, wrapTimeout(err)
 	}
 
 	switch {
+	case data[1] == function && isCustom && custom.ResponseLength != nil:
+		n, err = sf.readCustomResponse(custom.ResponseLength, aduRequest, data[:], n)
 	case data[1] == function:
 		// if the function is correct
 		// we read the rest of the bytes
@@ -169,16 +217,76 @@ func (sf *RTUClientProvider) SendRawFrame(aduRequest []byte) (aduResponse []byte
 		}
 		n += n1
 	default:
-		err = fmt.Errorf("modbus: unknown function code % x", data[1])
+		err = frameErrorf("unknown function code % x", data[1])
 	}
 	if err != nil {
-		return
+		return nil, wrapTimeout(err)
 	}
 	aduResponse = data[:n]
 	sf.Debugf("received [% x]", aduResponse)
 	return aduResponse, nil
 }
 
+// readCustomResponse read the rest of the user defined function response,
+// which length decided by the declaration, n is the bytes has been read.
+// Caller must hold the mutex before calling this method.
+func (sf *RTUClientProvider) readCustomResponse(responseLength ResponseLengthFunc,
+	aduRequest, data []byte, n int) (int, error) {
+	requestData := aduRequest[2 : len(aduRequest)-2]
+	for {
+		// address(1) + funcCode(1) + data + crc(2)
+		if length := responseLength(requestData, data[2:n]); length >= 0 {
+			total := length + rtuAduMinSize
+			if total > len(data) {
+				return n, frameErrorf("response length '%v' must not greater than '%v'", total, len(data))
+			}
+			if total > n {
+				n1, err := io.ReadFull(sf.port, data[n:total])
+				return n + n1, err
+			}
+			return total, nil
+		}
+		if n >= len(data) {
+			return n, frameErrorf("response length can not be decided")
+		}
+		n1, err := io.ReadFull(sf.port, data[n:n+1])
+		if n += n1; err != nil {
+			return n, err
+		}
+	}
+}
+
+// receive the response frame which is ended by the t3.5 silence.
+// Caller must hold the mutex before calling this method.
+func (sf *RTUClientProvider) receive() ([]byte, error) {
+	timeout := sf.Timeout
+	if timeout <= 0 {
+		timeout = SerialDefaultTimeout
+	}
+	aduResponse, err := sf.receiver.receive(timeout, rtuAduMaxSize)
+	if err != nil {
+		if sf.receiver.stopped() {
+			sf.close()
+		}
+		return nil, wrapTimeout(err)
+	}
+	sf.Debugf("received [% x]", aduResponse)
+	return aduResponse, nil
+}
+
+// frameSilence returns the configured t3.5 or derived it from the baud rate.
+func (sf *RTUClientProvider) frameSilence() time.Duration {
+	if sf.t35 > 0 {
+		return sf.t35
+	}
+	return calculateSilence(sf.BaudRate)
+}
+
+func (sf *RTUClientProvider) setFrameSilence(t35 time.Duration) {
+	sf.enableSilence = true
+	sf.t35 = t35
+}
+
 // calculateDelay roughly calculates time needed for the next frame.
 // See MODBUS over Serial Line - Specification and Implementation Guide (page 13).
 func (sf *RTUClientProvider) calculateDelay(chars int) time.Duration {
@@ -229,13 +337,13 @@ func calculateResponseLength(adu []byte) int {
 func verify(reqSlaveID, rspSlaveID uint8, reqPDU, rspPDU ProtocolDataUnit) error {
 	switch {
 	case reqSlaveID != rspSlaveID: // Check slaveID same
-		return fmt.Errorf("modbus: response slave id '%v' does not match request '%v'", rspSlaveID, reqSlaveID)
+		return &SlaveIDError{reqSlaveID, rspSlaveID}
 
 	case rspPDU.FuncCode != reqPDU.FuncCode: // Check correct function code returned (exception)
 		return responseError(rspPDU)
 
 	case rspPDU.Data == nil || len(rspPDU.Data) == 0: // check Empty response
-		return fmt.Errorf("modbus: response data is empty")
+		return frameErrorf("response data is empty")
 	}
 	return nil
 }
@@ -1,8 +1,11 @@
 package modbus
 
 import (
+	"errors"
+	"io"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestRTUClientProvider_encodeRTUFrame(t *testing.T) {
@@ -187,3 +190,41 @@ func BenchmarkRTUClientProvider_decodeRTUFrame(b *testing.B) {
 		}
 	}
 }
+
+func TestRTUClientProvider_broadcast(t *testing.T) {
+	port := newPipePort()
+	defer port.Close()
+
+	p := NewRTUClientProvider(WithTurnaroundDelay(50 * time.Millisecond))
+	p.port = port
+	received := make(chan []byte, 2)
+	go func() {
+		for {
+			req := make([]byte, 8)
+			if _, err := io.ReadFull(port.remote, req); err != nil {
+				return
+			}
+			received <- req
+		}
+	}()
+
+	client := NewClient(p, WithAddressMin(AddressBroadCast))
+	if err := client.WriteSingleCoil(AddressBroadCast, 1, true); err != nil {
+		t.Fatalf("WriteSingleCoil() error = %v, wantErr %v", err, nil)
+	}
+	if want := []byte{0x00, 0x05, 0x00, 0x01, 0xff, 0x00, 0xdc, 0x2b}; !reflect.DeepEqual(<-received, want) {
+		t.Errorf("WriteSingleCoil() sent unexpected frame, want % x", want)
+	}
+	if _, err := client.ReadCoils(AddressBroadCast, 0, 1); !errors.Is(err, ErrBroadcastRead) {
+		t.Errorf("ReadCoils() error = %v, wantErr %v", err, ErrBroadcastRead)
+	}
+
+	start := time.Now()
+	if err := client.WriteSingleRegister(AddressBroadCast, 1, 3); err != nil {
+		t.Fatalf("WriteSingleRegister() error = %v, wantErr %v", err, nil)
+	}
+	<-received
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("WriteSingleRegister() sent after %v, want turnaround delay %v", elapsed, 50*time.Millisecond)
+	}
+}
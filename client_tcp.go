@@ -22,6 +22,9 @@ const (
 
 // TCPClientProvider implements ClientProvider interface.
 type TCPClientProvider struct {
+	// count of discarded stale responses, keep it first for 64-bit alignment
+	discardedFrames uint64
+	capturer
 	logger
 	address string
 	mu      sync.Mutex
@@ -29,6 +32,8 @@ type TCPClientProvider struct {
 	conn net.Conn
 	// Connect & Read timeout
 	timeout time.Duration
+	// nil means net.Dialer with the timeout
+	dial DialFunc
 	// For synchronization between messages of server & client
 	transactionID uint32
 	// request
@@ -101,7 +106,7 @@ func (sf *protocolFrame) encodeTCPFrame(tid uint16, slaveID byte,
 //  Data            : 0 up to 252 bytes
 func decodeTCPFrame(adu []byte) (protocolTCPHeader, []byte, error) {
 	if len(adu) < tcpAduMinSize { // Minimum size (including MBAP, funcCode)
-		return protocolTCPHeader{}, nil, fmt.Errorf("modbus: response length '%v' does not meet minimum '%v'",
+		return protocolTCPHeader{}, nil, frameErrorf("response length '%v' does not meet minimum '%v'",
 			len(adu), tcpAduMinSize)
 	}
 	// Read length value in the header
@@ -114,8 +119,7 @@ func decodeTCPFrame(adu []byte) (protocolTCPHeader, []byte, error) {
 
 	pduLength := len(adu) - tcpHeaderMbapSize
 	if pduLength != int(head.length-1) {
-		return head, nil, fmt.Errorf("modbus: length in response '%v' does not match pdu data length '%v'",
-			head.length-1, pduLength)
+		return head, nil, &LengthError{"length in response", int(head.length - 1), pduLength}
 	}
 	// The first byte after header is function code
 	return head, adu[tcpHeaderMbapSize:], nil
@@ -125,22 +129,19 @@ func decodeTCPFrame(adu []byte) (protocolTCPHeader, []byte, error) {
 func verifyTCPFrame(reqHead, rspHead protocolTCPHeader, reqPDU, rspPDU ProtocolDataUnit) error {
 	switch {
 	case rspHead.transactionID != reqHead.transactionID: // Check transaction ID
-		return fmt.Errorf("modbus: response transaction id '%v' does not match request '%v'",
-			rspHead.transactionID, reqHead.transactionID)
+		return &TransactionError{reqHead.transactionID, rspHead.transactionID}
 
 	case rspHead.protocolID != reqHead.protocolID: // Check protocol ID
-		return fmt.Errorf("modbus: response protocol id '%v' does not match request '%v'",
-			rspHead.protocolID, reqHead.protocolID)
+		return &ProtocolError{reqHead.protocolID, rspHead.protocolID}
 
 	case rspHead.slaveID != reqHead.slaveID: // Check slaveID same
-		return fmt.Errorf("modbus: response unit id '%v' does not match request '%v'",
-			rspHead.slaveID, reqHead.slaveID)
+		return &SlaveIDError{reqHead.slaveID, rspHead.slaveID}
 
 	case rspPDU.FuncCode != reqPDU.FuncCode: // Check correct function code returned (exception)
 		return responseError(rspPDU)
 
 	case rspPDU.Data == nil || len(rspPDU.Data) == 0: // check Empty response
-		return fmt.Errorf("modbus: response data is empty")
+		return frameErrorf("response data is empty")
 	}
 	return nil
 }
@@ -209,14 +210,12 @@ func (sf *TCPClientProvider) SendRawFrame(aduRequest []byte) (aduResponse []byte
 	sf.mu.Lock()
 	defer sf.mu.Unlock()
 
-	if err = sf.connect(); err != nil {
-		return
+	if err = sf.writeFrame(aduRequest); err != nil {
+		return nil, err
 	}
-	// Send data
-	sf.Debugf("sending [% x]", aduRequest)
-	// Set write and read timeout
-	var timeout time.Time
 
+	// Set read timeout, stale responses are discarded under the same deadline
+	var timeout time.Time
 	if sf.timeout > 0 {
 		timeout = time.Now().Add(sf.timeout)
 	}
@@ -224,29 +223,82 @@ func (sf *TCPClientProvider) SendRawFrame(aduRequest []byte) (aduResponse []byte
 		return nil, err
 	}
 
-	if _, err = sf.conn.Write(aduRequest); err != nil {
-		return
+	for {
+		if aduResponse, err = sf.readFrame(); err != nil {
+			return nil, err
+		}
+		sf.capture(TransportTCP, false, sf.conn, aduResponse)
+		// response which transaction id does not match the request is a stale one,
+		// such as the response of a timeout request, drop it and read next.
+		if len(aduRequest) < tcpHeaderMbapSize ||
+			binary.BigEndian.Uint16(aduResponse) == binary.BigEndian.Uint16(aduRequest) {
+			sf.Debugf("received [% x]", aduResponse)
+			return aduResponse, nil
+		}
+		atomic.AddUint64(&sf.discardedFrames, 1)
+		sf.Debugf("discarded [% x]", aduResponse)
 	}
+}
 
-	// Read header first
-	var data [tcpAduMaxSize]byte
-	var cnt int
+// sendNoReply send pdu request without waiting for the response, such as a
+// broadcast, a late response is discarded by its transaction id.
+func (sf *TCPClientProvider) sendNoReply(slaveID byte, pduRequest []byte) error {
+	if len(pduRequest) < pduMinSize || len(pduRequest) > pduMaxSize {
+		return fmt.Errorf("modbus: pdu size '%v' must not be between '%v' and '%v'",
+			len(pduRequest), pduMinSize, pduMaxSize)
+	}
 
+	frame := sf.pool.get()
+	defer sf.pool.put(frame)
+	tid := uint16(atomic.AddUint32(&sf.transactionID, 1))
+	_, aduRequest, err := frame.encodeTCPFrame(tid, slaveID, ProtocolDataUnit{pduRequest[0], pduRequest[1:]})
+	if err != nil {
+		return err
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.writeFrame(aduRequest)
+}
+
+// writeFrame connect if needed and write the request frame.
+// Caller must hold the mutex before calling this method.
+func (sf *TCPClientProvider) writeFrame(aduRequest []byte) error {
+	if err := sf.connect(); err != nil {
+		return err
+	}
+	// Send data
+	sf.Debugf("sending [% x]", aduRequest)
+	// Set write timeout
+	var timeout time.Time
 	if sf.timeout > 0 {
 		timeout = time.Now().Add(sf.timeout)
 	}
-	if err = sf.conn.SetDeadline(timeout); err != nil {
-		return nil, err
+	if err := sf.conn.SetDeadline(timeout); err != nil {
+		return err
 	}
+	if _, err := sf.conn.Write(aduRequest); err != nil {
+		return wrapTimeout(err)
+	}
+	sf.capture(TransportTCP, true, sf.conn, aduRequest)
+	return nil
+}
 
-	if cnt, err = io.ReadFull(sf.conn, data[:tcpHeaderMbapSize]); err != nil {
+// readFrame read a whole frame from the connection.
+// Caller must hold the mutex before calling this method.
+func (sf *TCPClientProvider) readFrame() ([]byte, error) {
+	// Read header first
+	var data [tcpAduMaxSize]byte
+
+	cnt, err := io.ReadFull(sf.conn, data[:tcpHeaderMbapSize])
+	if err != nil {
 		if e, ok := err.(net.Error); (ok && !e.Temporary() && !e.Timeout()) ||
 			(err != io.EOF && err == io.ErrClosedPipe) ||
 			strings.Contains(err.Error(), "use of closed network connection") ||
 			(cnt == 0 && err == io.EOF) {
 			sf.close()
 		}
-		return
+		return nil, wrapTimeout(err)
 	}
 
 	// Read length, ignore transaction & protocol id (4 bytes)
@@ -254,30 +306,25 @@ func (sf *TCPClientProvider) SendRawFrame(aduRequest []byte) (aduResponse []byte
 	switch {
 	case length <= 0:
 		_ = sf.flush(data[:])
-		err = fmt.Errorf("modbus: length in response header '%v' must not be zero", length)
-		return
+		return nil, frameErrorf("length in response header '%v' must not be zero", length)
 	case length > (tcpAduMaxSize - (tcpHeaderMbapSize - 1)):
 		_ = sf.flush(data[:])
-		err = fmt.Errorf("modbus: length in response header '%v' must not greater than '%v'",
+		return nil, frameErrorf("length in response header '%v' must not greater than '%v'",
 			length, tcpAduMaxSize-tcpHeaderMbapSize+1)
-		return
-	}
-
-	if sf.timeout > 0 {
-		timeout = time.Now().Add(sf.timeout)
-	}
-	if err = sf.conn.SetDeadline(timeout); err != nil {
-		return nil, err
 	}
 
 	// Skip unit id
 	length += tcpHeaderMbapSize - 1
 	if _, err = io.ReadFull(sf.conn, data[tcpHeaderMbapSize:length]); err != nil {
-		return
+		return nil, wrapTimeout(err)
 	}
-	aduResponse = data[:length]
-	sf.Debugf("received [% x]", aduResponse)
-	return aduResponse, nil
+	return data[:length], nil
+}
+
+// DiscardedFrames returns the count of stale or out-of-order responses which
+// had been discarded because of the transaction id mismatch.
+func (sf *TCPClientProvider) DiscardedFrames() uint64 {
+	return atomic.LoadUint64(&sf.discardedFrames)
 }
 
 // Connect establishes a new connection to the address in Address.
@@ -292,8 +339,11 @@ func (sf *TCPClientProvider) Connect() error {
 // Caller must hold the mutex before calling this method.
 func (sf *TCPClientProvider) connect() error {
 	if sf.conn == nil {
-		dialer := &net.Dialer{Timeout: sf.timeout}
-		conn, err := dialer.Dial("tcp", sf.address)
+		dial := sf.dial
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: sf.timeout}).Dial
+		}
+		conn, err := dial("tcp", sf.address)
 		if err != nil {
 			return err
 		}
@@ -334,6 +384,18 @@ func (sf *TCPClientProvider) setTCPTimeout(t time.Duration) {
 	sf.timeout = t
 }
 
+func (sf *TCPClientProvider) setFrameSilence(time.Duration) {}
+
+func (sf *TCPClientProvider) setTurnaroundDelay(time.Duration) {}
+
+func (sf *TCPClientProvider) addCustomFunction(...CustomFunction) {}
+
+func (sf *TCPClientProvider) setDialer(dial DialFunc) {
+	sf.dial = dial
+}
+
+func (sf *TCPClientProvider) setPortOpener(PortOpenFunc) {}
+
 // flush flushes pending data in the connection,
 // returns io.EOF if connection is closed.
 func (sf *TCPClientProvider) flush(b []byte) (err error) {
@@ -1,6 +1,9 @@
 package modbus
 
 import (
+	"encoding/binary"
+	"io"
+	"net"
 	"reflect"
 	"testing"
 )
@@ -195,3 +198,41 @@ func BenchmarkTCPClientProvider_decodeTCPFrame(b *testing.B) {
 		}
 	}
 }
+
+func TestTCPClientProvider_discardStaleFrame(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req := make([]byte, 12)
+		if _, err = io.ReadFull(conn, req); err != nil {
+			return
+		}
+		// stale response of the previous transaction first, then the matching one
+		stale := []byte{0, 0, 0, 0, 0, 5, 1, 3, 2, 0x11, 0x11}
+		binary.BigEndian.PutUint16(stale, binary.BigEndian.Uint16(req)-1)
+		rsp := []byte{req[0], req[1], 0, 0, 0, 5, 1, 3, 2, 0x12, 0x34}
+		_, _ = conn.Write(append(stale, rsp...))
+	}()
+
+	p := NewTCPClientProvider(ln.Addr().String())
+	defer p.Close()
+	got, err := NewClient(p).ReadHoldingRegisters(1, 0, 1)
+	if err != nil {
+		t.Fatalf("ReadHoldingRegisters() error = %v, wantErr %v", err, nil)
+	}
+	if !reflect.DeepEqual(got, []uint16{0x1234}) {
+		t.Errorf("ReadHoldingRegisters() = %#v, want %#v", got, []uint16{0x1234})
+	}
+	if p.DiscardedFrames() != 1 {
+		t.Errorf("DiscardedFrames() = %v, want %v", p.DiscardedFrames(), 1)
+	}
+}
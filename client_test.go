@@ -31,9 +31,15 @@ func (*provider) SendPdu(byte, []byte) (pduResponse []byte, err error) {
 func (*provider) SendRawFrame([]byte) (aduResponse []byte, err error) {
 	return nil, nil
 }
-func (*provider) setLogProvider(LogProvider)    {}
-func (*provider) setSerialConfig(serial.Config) {}
-func (*provider) setTCPTimeout(time.Duration)   {}
+func (*provider) setLogProvider(LogProvider)          {}
+func (*provider) setSerialConfig(serial.Config)       {}
+func (*provider) setTCPTimeout(time.Duration)         {}
+func (*provider) setFrameSilence(time.Duration)       {}
+func (*provider) setTurnaroundDelay(time.Duration)    {}
+func (*provider) addCustomFunction(...CustomFunction) {}
+func (*provider) setCapture(CaptureSink)              {}
+func (*provider) setDialer(DialFunc)                  {}
+func (*provider) setPortOpener(PortOpenFunc)          {}
 
 func Test_client_ReadCoils(t *testing.T) {
 	type args struct {
@@ -0,0 +1,405 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Transport 帧的传输方式
+type Transport byte
+
+// transports
+const (
+	TransportTCP Transport = iota
+	TransportRTU
+	TransportASCII
+)
+
+// String implements fmt.Stringer.
+func (t Transport) String() string {
+	switch t {
+	case TransportTCP:
+		return "tcp"
+	case TransportRTU:
+		return "rtu"
+	case TransportASCII:
+		return "ascii"
+	default:
+		return "unknown"
+	}
+}
+
+// Direction 帧的方向
+type Direction byte
+
+// directions
+const (
+	DirectionRequest Direction = iota
+	DirectionResponse
+)
+
+// String implements fmt.Stringer.
+func (d Direction) String() string {
+	if d == DirectionResponse {
+		return "response"
+	}
+	return "request"
+}
+
+// Dissection 帧的结构化描述, 字段尽可能解析, 帧中没有的字段为零值.
+type Dissection struct {
+	Transport Transport
+	Direction Direction
+	// modbus tcp mbap header
+	TransactionID uint16
+	ProtocolID    uint16
+	Length        uint16
+
+	SlaveID  byte
+	FuncCode byte   // with the exception bit
+	Function string // function name, such as "Read Holding Registers"
+	Data     []byte // pdu data without function code
+
+	Exception     byte // exception code, 0 means not an exception response
+	ExceptionName string
+
+	// Address, Quantity 请求或应答回显的首地址和数量, 读写多个寄存器为读的范围
+	Address  uint16
+	Quantity uint16
+	// WriteAddress, WriteQuantity 读写多个寄存器的写范围
+	WriteAddress  uint16
+	WriteQuantity uint16
+	// ByteCount 字节数字段, 帧中没有时为0
+	ByteCount int
+	// Values 写入或读出的值, 位为0或1, 读位应答含字节数*8个位,
+	// 屏蔽写寄存器为[and mask, or mask]
+	Values []uint16
+
+	// Checksum rtu帧的crc或ascii帧的lrc
+	Checksum uint16
+	// Problems 校验发现的问题, 有效的帧为空
+	Problems []string
+}
+
+// Valid reports whether no problem is found.
+func (d *Dissection) Valid() bool {
+	return len(d.Problems) == 0
+}
+
+// String implements fmt.Stringer, one line for logs.
+func (d *Dissection) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v %v", d.Transport, d.Direction)
+	if d.Transport == TransportTCP {
+		fmt.Fprintf(&b, " tid %d pid %d len %d", d.TransactionID, d.ProtocolID, d.Length)
+	}
+	fmt.Fprintf(&b, " slave %d func 0x%02x(%s)", d.SlaveID, d.FuncCode, d.Function)
+	switch {
+	case d.Exception != 0:
+		fmt.Fprintf(&b, " exception %d(%s)", d.Exception, d.ExceptionName)
+	default:
+		if d.Address != 0 || d.Quantity != 0 {
+			fmt.Fprintf(&b, " address %d quantity %d", d.Address, d.Quantity)
+		}
+		if d.FuncCode == FuncCodeReadWriteMultipleRegisters && d.Direction == DirectionRequest {
+			fmt.Fprintf(&b, " write address %d quantity %d", d.WriteAddress, d.WriteQuantity)
+		}
+		if d.ByteCount != 0 {
+			fmt.Fprintf(&b, " byte count %d", d.ByteCount)
+		}
+		if d.Values != nil {
+			fmt.Fprintf(&b, " values %v", d.Values)
+		}
+	}
+	if len(d.Problems) > 0 {
+		fmt.Fprintf(&b, " problems: %s", strings.Join(d.Problems, "; "))
+	}
+	return b.String()
+}
+
+func (d *Dissection) problemf(format string, v ...interface{}) {
+	d.Problems = append(d.Problems, fmt.Sprintf(format, v...))
+}
+
+// functionNames the names of public function codes.
+var functionNames = map[byte]string{
+	FuncCodeReadCoils:                  "Read Coils",
+	FuncCodeReadDiscreteInputs:         "Read Discrete Inputs",
+	FuncCodeReadHoldingRegisters:       "Read Holding Registers",
+	FuncCodeReadInputRegisters:         "Read Input Registers",
+	FuncCodeWriteSingleCoil:            "Write Single Coil",
+	FuncCodeWriteSingleRegister:        "Write Single Register",
+	7:                                  "Read Exception Status",
+	8:                                  "Diagnostics",
+	11:                                 "Get Comm Event Counter",
+	12:                                 "Get Comm Event Log",
+	FuncCodeWriteMultipleCoils:         "Write Multiple Coils",
+	FuncCodeWriteMultipleRegisters:     "Write Multiple Registers",
+	FuncCodeOtherReportSlaveID:         "Report Server ID",
+	20:                                 "Read File Record",
+	21:                                 "Write File Record",
+	FuncCodeMaskWriteRegister:          "Mask Write Register",
+	FuncCodeReadWriteMultipleRegisters: "Read/Write Multiple Registers",
+	FuncCodeReadFIFOQueue:              "Read FIFO Queue",
+	43:                                 "Encapsulated Interface Transport",
+}
+
+// Dissect 将adu(请求或应答)解析为结构化描述, 尽可能解析各字段,
+// 发现的问题记录在Problems中, 而不是返回错误.
+func Dissect(transport Transport, direction Direction, adu []byte) *Dissection {
+	d := &Dissection{Transport: transport, Direction: direction}
+	var pdu []byte
+	switch transport {
+	case TransportTCP:
+		pdu = d.tcpHeader(adu)
+	case TransportRTU:
+		pdu = d.rtuFrame(adu)
+	case TransportASCII:
+		pdu = d.asciiFrame(adu)
+	default:
+		d.problemf("unknown transport %d", transport)
+	}
+	if len(pdu) == 0 {
+		d.problemf("missing function code")
+		return d
+	}
+	if len(pdu) > pduMaxSize {
+		d.problemf("pdu length %d exceeds %d", len(pdu), pduMaxSize)
+	}
+
+	d.FuncCode, d.Data = pdu[0], pdu[1:]
+	function := d.FuncCode & 0x7f
+	if name, ok := functionNames[function]; ok {
+		d.Function = name
+	} else {
+		d.Function = "Unknown"
+	}
+	switch {
+	case d.FuncCode == 0:
+		d.problemf("invalid function code 0")
+	case d.FuncCode&0x80 != 0 && direction == DirectionRequest:
+		d.problemf("invalid function code 0x%02x in request", d.FuncCode)
+	case d.FuncCode&0x80 != 0:
+		d.exception()
+	case direction == DirectionRequest:
+		d.request()
+	default:
+		d.response()
+	}
+	return d
+}
+
+func (d *Dissection) tcpHeader(adu []byte) []byte {
+	if len(adu) < tcpHeaderMbapSize {
+		d.problemf("frame length %d shorter than mbap header %d", len(adu), tcpHeaderMbapSize)
+		return nil
+	}
+	d.TransactionID = binary.BigEndian.Uint16(adu)
+	d.ProtocolID = binary.BigEndian.Uint16(adu[2:])
+	d.Length = binary.BigEndian.Uint16(adu[4:])
+	d.SlaveID = adu[6]
+	if d.ProtocolID != tcpProtocolIdentifier {
+		d.problemf("protocol id %d, want %d", d.ProtocolID, tcpProtocolIdentifier)
+	}
+	if int(d.Length) != len(adu)-tcpHeaderMbapSize+1 {
+		d.problemf("length field %d, want %d", d.Length, len(adu)-tcpHeaderMbapSize+1)
+	}
+	return adu[tcpHeaderMbapSize:]
+}
+
+func (d *Dissection) rtuFrame(adu []byte) []byte {
+	if len(adu) < rtuAduMinSize {
+		d.problemf("frame length %d shorter than %d", len(adu), rtuAduMinSize)
+		if len(adu) > 0 {
+			d.SlaveID = adu[0]
+		}
+		return nil
+	}
+	if len(adu) > rtuAduMaxSize {
+		d.problemf("frame length %d exceeds %d", len(adu), rtuAduMaxSize)
+	}
+	d.SlaveID = adu[0]
+	d.Checksum = binary.LittleEndian.Uint16(adu[len(adu)-2:])
+	if crc := CRC16(adu[:len(adu)-2]); crc != d.Checksum {
+		d.problemf("crc 0x%04x, want 0x%04x", d.Checksum, crc)
+	}
+	return adu[1 : len(adu)-2]
+}
+
+func (d *Dissection) asciiFrame(adu []byte) []byte {
+	if !strings.HasPrefix(string(adu), asciiStart) {
+		d.problemf("frame not started with ':'")
+	} else {
+		adu = adu[len(asciiStart):]
+	}
+	if !strings.HasSuffix(string(adu), asciiEnd) {
+		d.problemf("frame not ended with CR LF")
+	} else {
+		adu = adu[:len(adu)-len(asciiEnd)]
+	}
+	if len(adu)%2 != 0 {
+		d.problemf("odd number of hex characters %d", len(adu))
+		adu = adu[:len(adu)-1]
+	}
+	raw := make([]byte, hex.DecodedLen(len(adu)))
+	if _, err := hex.Decode(raw, adu); err != nil {
+		d.problemf("invalid hex characters, %v", err)
+		return nil
+	}
+	if len(raw) < 3 { // address, function, lrc
+		d.problemf("frame length %d shorter than 3 bytes", len(raw))
+		if len(raw) > 0 {
+			d.SlaveID = raw[0]
+		}
+		return nil
+	}
+	d.SlaveID = raw[0]
+	d.Checksum = uint16(raw[len(raw)-1])
+	if lrc := new(LRC).Reset().Push(raw[:len(raw)-1]...).Value(); uint16(lrc) != d.Checksum {
+		d.problemf("lrc 0x%02x, want 0x%02x", d.Checksum, lrc)
+	}
+	return raw[1 : len(raw)-1]
+}
+
+func (d *Dissection) exception() {
+	if len(d.Data) != 1 {
+		d.problemf("exception data length %d, want 1", len(d.Data))
+	}
+	if len(d.Data) > 0 {
+		d.Exception = d.Data[0]
+		d.ExceptionName = exceptionName(d.Exception)
+		if d.ExceptionName == "unknown" {
+			d.problemf("unknown exception code %d", d.Exception)
+		}
+	}
+}
+
+// fixed 校验数据域长度, 长度不足返回false
+func (d *Dissection) fixed(size int) bool {
+	if len(d.Data) != size {
+		d.problemf("pdu data length %d, want %d", len(d.Data), size)
+	}
+	return len(d.Data) >= size
+}
+
+// quantity 校验数量范围
+func (d *Dissection) quantity(quantity, min, max uint16) {
+	if quantity < min || quantity > max {
+		d.problemf("quantity %d out of range [%d, %d]", quantity, min, max)
+	}
+}
+
+// byteCount 解析字节数字段及其后的数据, 校验与want及实际长度相符, want < 0 不校验
+func (d *Dissection) byteCount(pos, want int) []byte {
+	if len(d.Data) <= pos {
+		d.problemf("missing byte count")
+		return nil
+	}
+	d.ByteCount = int(d.Data[pos])
+	values := d.Data[pos+1:]
+	if want >= 0 && d.ByteCount != want {
+		d.problemf("byte count %d, want %d", d.ByteCount, want)
+	}
+	if len(values) != d.ByteCount {
+		d.problemf("byte count %d, but %d bytes follow", d.ByteCount, len(values))
+	}
+	return values
+}
+
+func (d *Dissection) request() {
+	data := d.Data
+	switch d.FuncCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		if d.fixed(4) {
+			d.Address, d.Quantity = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+			d.quantity(d.Quantity, ReadBitsQuantityMin, ReadBitsQuantityMax)
+		}
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		if d.fixed(4) {
+			d.Address, d.Quantity = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+			d.quantity(d.Quantity, ReadRegQuantityMin, ReadRegQuantityMax)
+		}
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister:
+		d.singleWrite()
+	case FuncCodeWriteMultipleCoils:
+		if len(data) < 4 {
+			d.problemf("pdu data length %d shorter than %d", len(data), FuncWriteMultiMinSize)
+			return
+		}
+		d.Address, d.Quantity = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		d.quantity(d.Quantity, WriteBitsQuantityMin, WriteBitsQuantityMax)
+		if values := d.byteCount(4, (int(d.Quantity)+7)/8); len(values)*8 >= int(d.Quantity) {
+			d.Values = bitsValue(values, 0, d.Quantity)
+		}
+	case FuncCodeWriteMultipleRegisters:
+		if len(data) < 4 {
+			d.problemf("pdu data length %d shorter than %d", len(data), FuncWriteMultiMinSize)
+			return
+		}
+		d.Address, d.Quantity = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		d.quantity(d.Quantity, WriteRegQuantityMin, WriteRegQuantityMax)
+		d.Values = bytes2Uint16(d.byteCount(4, int(d.Quantity)*2))
+	case FuncCodeMaskWriteRegister:
+		if d.fixed(6) {
+			d.Address, d.Quantity = binary.BigEndian.Uint16(data), 1
+			d.Values = bytes2Uint16(data[2:6])
+		}
+	case FuncCodeReadWriteMultipleRegisters:
+		if len(data) < 8 {
+			d.problemf("pdu data length %d shorter than %d", len(data), FuncReadWriteMinSize)
+			return
+		}
+		d.Address, d.Quantity = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		d.WriteAddress, d.WriteQuantity = binary.BigEndian.Uint16(data[4:]), binary.BigEndian.Uint16(data[6:])
+		d.quantity(d.Quantity, ReadWriteOnReadRegQuantityMin, ReadWriteOnReadRegQuantityMax)
+		d.quantity(d.WriteQuantity, ReadWriteOnWriteRegQuantityMin, ReadWriteOnWriteRegQuantityMax)
+		d.Values = bytes2Uint16(d.byteCount(8, int(d.WriteQuantity)*2))
+	}
+}
+
+func (d *Dissection) response() {
+	data := d.Data
+	switch d.FuncCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		values := d.byteCount(0, -1)
+		d.Values = bitsValue(values, 0, uint16(len(values)*8))
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters, FuncCodeReadWriteMultipleRegisters:
+		values := d.byteCount(0, -1)
+		if d.ByteCount%2 != 0 {
+			d.problemf("byte count %d is not even", d.ByteCount)
+		}
+		d.Values = bytes2Uint16(values)
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister:
+		d.singleWrite()
+	case FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		if d.fixed(4) {
+			d.Address, d.Quantity = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		}
+	case FuncCodeMaskWriteRegister:
+		if d.fixed(6) {
+			d.Address, d.Quantity = binary.BigEndian.Uint16(data), 1
+			d.Values = bytes2Uint16(data[2:6])
+		}
+	}
+}
+
+// singleWrite 写单个线圈或寄存器, 请求与应答相同
+func (d *Dissection) singleWrite() {
+	if !d.fixed(4) {
+		return
+	}
+	d.Address, d.Quantity = binary.BigEndian.Uint16(d.Data), 1
+	value := binary.BigEndian.Uint16(d.Data[2:])
+	if d.FuncCode == FuncCodeWriteSingleCoil {
+		switch value {
+		case 0xff00:
+			value = 1
+		case 0x0000:
+		default:
+			d.problemf("coil value 0x%04x, want 0xff00 or 0x0000", value)
+		}
+	}
+	d.Values = []uint16{value}
+}
@@ -0,0 +1,73 @@
+package modbus
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDissect(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport Transport
+		direction Direction
+		adu       []byte
+		want      Dissection
+		problems  int
+	}{
+		{"tcp read holding request", TransportTCP, DirectionRequest,
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x6b, 0x00, 0x03},
+			Dissection{TransactionID: 1, Length: 6, SlaveID: 0x11, FuncCode: 0x03, Function: "Read Holding Registers",
+				Data: []byte{0x00, 0x6b, 0x00, 0x03}, Address: 0x6b, Quantity: 3}, 0},
+		{"tcp read holding response", TransportTCP, DirectionResponse,
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x07, 0x11, 0x03, 0x04, 0x02, 0x2b, 0x00, 0x00},
+			Dissection{TransactionID: 1, Length: 7, SlaveID: 0x11, FuncCode: 0x03, Function: "Read Holding Registers",
+				Data: []byte{0x04, 0x02, 0x2b, 0x00, 0x00}, ByteCount: 4, Values: []uint16{0x022b, 0}}, 0},
+		{"tcp wrong length and protocol", TransportTCP, DirectionRequest,
+			[]byte{0x00, 0x01, 0x00, 0x01, 0x00, 0x09, 0x11, 0x03, 0x00, 0x6b, 0x00, 0x03},
+			Dissection{TransactionID: 1, ProtocolID: 1, Length: 9, SlaveID: 0x11, FuncCode: 0x03, Function: "Read Holding Registers",
+				Data: []byte{0x00, 0x6b, 0x00, 0x03}, Address: 0x6b, Quantity: 3}, 2},
+		{"rtu write multiple coils request", TransportRTU, DirectionRequest,
+			rtuFrame(0x01, 0x0f, 0x00, 0x13, 0x00, 0x0a, 0x02, 0xcd, 0x01),
+			Dissection{SlaveID: 0x01, FuncCode: 0x0f, Function: "Write Multiple Coils",
+				Data: []byte{0x00, 0x13, 0x00, 0x0a, 0x02, 0xcd, 0x01}, Address: 0x13, Quantity: 10, ByteCount: 2,
+				Values: []uint16{1, 0, 1, 1, 0, 0, 1, 1, 1, 0}}, 0},
+		{"rtu exception response", TransportRTU, DirectionResponse,
+			rtuFrame(0x01, 0x83, 0x02),
+			Dissection{SlaveID: 0x01, FuncCode: 0x83, Function: "Read Holding Registers",
+				Data: []byte{0x02}, Exception: 2, ExceptionName: "illegal data address"}, 0},
+		{"rtu bad crc and quantity", TransportRTU, DirectionRequest,
+			[]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x7e, 0x00, 0x00},
+			Dissection{SlaveID: 0x01, FuncCode: 0x03, Function: "Read Holding Registers",
+				Data: []byte{0x00, 0x00, 0x00, 0x7e}, Quantity: 0x7e}, 2},
+		{"rtu byte count mismatch", TransportRTU, DirectionRequest,
+			rtuFrame(0x01, 0x10, 0x00, 0x01, 0x00, 0x02, 0x03, 0x00, 0x0a, 0x01),
+			Dissection{SlaveID: 0x01, FuncCode: 0x10, Function: "Write Multiple Registers",
+				Data: []byte{0x00, 0x01, 0x00, 0x02, 0x03, 0x00, 0x0a, 0x01}, Address: 1, Quantity: 2, ByteCount: 3,
+				Values: []uint16{0x000a}}, 1},
+		{"ascii write single coil", TransportASCII, DirectionRequest,
+			[]byte(":01050001FF00FA\r\n"),
+			Dissection{SlaveID: 0x01, FuncCode: 0x05, Function: "Write Single Coil",
+				Data: []byte{0x00, 0x01, 0xff, 0x00}, Address: 1, Quantity: 1, Values: []uint16{1}}, 0},
+		{"ascii bad lrc and coil value", TransportASCII, DirectionResponse,
+			[]byte(":010500011234FF\r\n"),
+			Dissection{SlaveID: 0x01, FuncCode: 0x05, Function: "Write Single Coil",
+				Data: []byte{0x00, 0x01, 0x12, 0x34}, Address: 1, Quantity: 1, Values: []uint16{0x1234}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Dissect(tt.transport, tt.direction, tt.adu)
+			if len(got.Problems) != tt.problems {
+				t.Errorf("Dissect() problems = %q, want %d problems", got.Problems, tt.problems)
+			}
+			if tt.problems == 0 && !got.Valid() || !strings.Contains(got.String(), got.Function) {
+				t.Errorf("Dissect() String() = %v", got)
+			}
+			got.Problems, got.Checksum = nil, 0
+			tt.want.Transport, tt.want.Direction = tt.transport, tt.direction
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Dissect() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/goburrow/serial"
+)
+
+// sentinel errors, every typed error below matches one of them with errors.Is.
+var (
+	// ErrTimeout transport read or write timeout.
+	ErrTimeout = errors.New("modbus: timeout")
+	// ErrCRCMismatch rtu frame crc check failed.
+	ErrCRCMismatch = errors.New("modbus: crc mismatch")
+	// ErrLRCMismatch ascii frame lrc check failed.
+	ErrLRCMismatch = errors.New("modbus: lrc mismatch")
+	// ErrTransactionMismatch tcp response transaction id does not match request.
+	ErrTransactionMismatch = errors.New("modbus: transaction id mismatch")
+	// ErrProtocolMismatch tcp response protocol id does not match request.
+	ErrProtocolMismatch = errors.New("modbus: protocol id mismatch")
+	// ErrSlaveIDMismatch response slave(unit) id does not match request.
+	ErrSlaveIDMismatch = errors.New("modbus: slave id mismatch")
+	// ErrLengthMismatch length field or byte count does not match the data.
+	ErrLengthMismatch = errors.New("modbus: length mismatch")
+	// ErrInvalidFrame frame is malformed.
+	ErrInvalidFrame = errors.New("modbus: invalid frame")
+	// ErrResponseMismatch response field does not echo the request.
+	ErrResponseMismatch = errors.New("modbus: response mismatch")
+	// ErrBroadcastRead read function addressed to the broadcast slave id.
+	ErrBroadcastRead = errors.New("modbus: read function can not be broadcast")
+)
+
+// TimeoutError wraps the underlying transport timeout error.
+type TimeoutError struct {
+	Err error
+}
+
+// Error implements error interface.
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("modbus: timeout, %v", e.Err)
+}
+
+// Unwrap returns the underlying transport error.
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrTimeout.
+func (e *TimeoutError) Is(target error) bool { return target == ErrTimeout }
+
+// Timeout implements net.Error.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Temporary implements net.Error.
+func (e *TimeoutError) Temporary() bool { return true }
+
+// CRCError rtu frame crc check failed.
+type CRCError struct {
+	Expected uint16 // calculated from the frame
+	Actual   uint16 // carried in the frame
+}
+
+// Error implements error interface.
+func (e *CRCError) Error() string {
+	return fmt.Sprintf("modbus: response crc '%x' does not match expected '%x'", e.Actual, e.Expected)
+}
+
+// Is reports whether target is ErrCRCMismatch.
+func (e *CRCError) Is(target error) bool { return target == ErrCRCMismatch }
+
+// LRCError ascii frame lrc check failed.
+type LRCError struct {
+	Expected byte // calculated from the frame
+	Actual   byte // carried in the frame
+}
+
+// Error implements error interface.
+func (e *LRCError) Error() string {
+	return fmt.Sprintf("modbus: response lrc '%x' does not match expected '%x'", e.Actual, e.Expected)
+}
+
+// Is reports whether target is ErrLRCMismatch.
+func (e *LRCError) Is(target error) bool { return target == ErrLRCMismatch }
+
+// TransactionError tcp response transaction id does not match request.
+type TransactionError struct {
+	Request  uint16
+	Response uint16
+}
+
+// Error implements error interface.
+func (e *TransactionError) Error() string {
+	return fmt.Sprintf("modbus: response transaction id '%v' does not match request '%v'", e.Response, e.Request)
+}
+
+// Is reports whether target is ErrTransactionMismatch.
+func (e *TransactionError) Is(target error) bool { return target == ErrTransactionMismatch }
+
+// ProtocolError tcp response protocol id does not match request.
+type ProtocolError struct {
+	Request  uint16
+	Response uint16
+}
+
+// Error implements error interface.
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("modbus: response protocol id '%v' does not match request '%v'", e.Response, e.Request)
+}
+
+// Is reports whether target is ErrProtocolMismatch.
+func (e *ProtocolError) Is(target error) bool { return target == ErrProtocolMismatch }
+
+// SlaveIDError response slave(unit) id does not match request.
+type SlaveIDError struct {
+	Request  byte
+	Response byte
+}
+
+// Error implements error interface.
+func (e *SlaveIDError) Error() string {
+	return fmt.Sprintf("modbus: response slave id '%v' does not match request '%v'", e.Response, e.Request)
+}
+
+// Is reports whether target is ErrSlaveIDMismatch.
+func (e *SlaveIDError) Is(target error) bool { return target == ErrSlaveIDMismatch }
+
+// LengthError length field or byte count does not match the data.
+type LengthError struct {
+	Field    string // which length, such as "response byte size"
+	Length   int    // actual value
+	Expected int    // expected value
+}
+
+// Error implements error interface.
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("modbus: %s '%v' does not match expected '%v'", e.Field, e.Length, e.Expected)
+}
+
+// Is reports whether target is ErrLengthMismatch.
+func (e *LengthError) Is(target error) bool { return target == ErrLengthMismatch }
+
+// FrameError frame is malformed.
+type FrameError struct {
+	Reason string
+}
+
+// Error implements error interface.
+func (e *FrameError) Error() string {
+	return "modbus: " + e.Reason
+}
+
+// Is reports whether target is ErrInvalidFrame.
+func (e *FrameError) Is(target error) bool { return target == ErrInvalidFrame }
+
+// ResponseError response field does not echo the request.
+type ResponseError struct {
+	Field    string // which field, such as "address"
+	Request  uint16
+	Response uint16
+}
+
+// Error implements error interface.
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("modbus: response %s '%v' does not match request '%v'", e.Field, e.Response, e.Request)
+}
+
+// Is reports whether target is ErrResponseMismatch.
+func (e *ResponseError) Is(target error) bool { return target == ErrResponseMismatch }
+
+// BroadcastError function which is not a write one addressed to the broadcast slave id.
+type BroadcastError struct {
+	FuncCode byte
+}
+
+// Error implements error interface.
+func (e *BroadcastError) Error() string {
+	return fmt.Sprintf("modbus: function '%v' can not be broadcast", e.FuncCode)
+}
+
+// Is reports whether target is ErrBroadcastRead.
+func (e *BroadcastError) Is(target error) bool { return target == ErrBroadcastRead }
+
+// frameErrorf new FrameError with format reason.
+func frameErrorf(format string, v ...interface{}) error {
+	return &FrameError{fmt.Sprintf(format, v...)}
+}
+
+// wrapTimeout wraps err into TimeoutError if it is a net or serial timeout.
+func wrapTimeout(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == serial.ErrTimeout {
+		return &TimeoutError{err}
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		if _, ok = err.(*TimeoutError); !ok {
+			return &TimeoutError{err}
+		}
+	}
+	return err
+}
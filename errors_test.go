@@ -0,0 +1,69 @@
+package modbus
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/goburrow/serial"
+)
+
+func Test_errorsIs(t *testing.T) {
+	_, _, crcErr := decodeRTUFrame([]byte{0x01, 0x03, 0x01, 0x02, 0x03, 0x04, 0x05, 0x05, 0x49})
+	_, _, lrcErr := decodeASCIIFrame([]byte(":010300010001FB\r\n"))
+	_, _, shortErr := decodeTCPFrame([]byte{0, 0, 0, 0, 0, 2, 0})
+	_, _, lengthErr := decodeTCPFrame([]byte{0, 0, 0, 0, 0, 11, 0, 1, 1, 2})
+	tidErr := verifyTCPFrame(protocolTCPHeader{1, 0, 6, 1}, protocolTCPHeader{2, 0, 6, 1},
+		ProtocolDataUnit{3, []byte{1}}, ProtocolDataUnit{3, []byte{1}})
+	slaveErr := verify(1, 2, ProtocolDataUnit{3, []byte{1}}, ProtocolDataUnit{3, []byte{1}})
+	exceptionErr := verify(1, 1, ProtocolDataUnit{3, []byte{1}}, ProtocolDataUnit{0x83, []byte{2}})
+
+	tests := []struct {
+		name   string
+		err    error
+		target error
+		want   bool
+	}{
+		{"crc", crcErr, ErrCRCMismatch, true},
+		{"crc not lrc", crcErr, ErrLRCMismatch, false},
+		{"lrc", lrcErr, ErrLRCMismatch, true},
+		{"short frame", shortErr, ErrInvalidFrame, true},
+		{"length", lengthErr, ErrLengthMismatch, true},
+		{"transaction id", tidErr, ErrTransactionMismatch, true},
+		{"slave id", slaveErr, ErrSlaveIDMismatch, true},
+		{"exception", exceptionErr, &ExceptionError{ExceptionCodeIllegalDataAddress}, true},
+		{"exception different code", exceptionErr, &ExceptionError{ExceptionCodeIllegalFunction}, false},
+		{"serial timeout", wrapTimeout(serial.ErrTimeout), ErrTimeout, true},
+		{"serial timeout unwrap", wrapTimeout(serial.ErrTimeout), serial.ErrTimeout, true},
+		{"net timeout", wrapTimeout(&net.OpError{Op: "read", Err: &TimeoutError{}}), ErrTimeout, true},
+		{"not timeout", wrapTimeout(errors.New("other")), ErrTimeout, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, tt.target); got != tt.want {
+				t.Errorf("errors.Is(%v, %v) = %v, want %v", tt.err, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_errorsAs(t *testing.T) {
+	_, _, err := decodeRTUFrame([]byte{0x01, 0x03, 0x01, 0x02, 0x03, 0x04, 0x05, 0x05, 0x49})
+	var crcErr *CRCError
+	if !errors.As(err, &crcErr) {
+		t.Fatalf("errors.As(%v, *CRCError) = false, want true", err)
+	}
+	if crcErr.Expected != 0x4805 || crcErr.Actual != 0x4905 {
+		t.Errorf("CRCError = %#v, want expected %x actual %x", crcErr, 0x4805, 0x4905)
+	}
+
+	err = verifyTCPFrame(protocolTCPHeader{1, 0, 6, 1}, protocolTCPHeader{2, 0, 6, 1},
+		ProtocolDataUnit{3, []byte{1}}, ProtocolDataUnit{3, []byte{1}})
+	var tidErr *TransactionError
+	if !errors.As(err, &tidErr) {
+		t.Fatalf("errors.As(%v, *TransactionError) = false, want true", err)
+	}
+	if tidErr.Request != 1 || tidErr.Response != 2 {
+		t.Errorf("TransactionError = %#v, want request 1 response 2", tidErr)
+	}
+}
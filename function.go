@@ -17,15 +17,39 @@ const (
 
 // FunctionHandler 功能码对应的函数回调.
 // data 仅pdu数据域 不含功能码, return pdu 数据域,不含功能码.
-type FunctionHandler func(reg *NodeRegister, data []byte) ([]byte, error)
+type FunctionHandler func(reg NodeStorage, data []byte) ([]byte, error)
+
+// UnknownUnitPolicy 请求的从站地址没有节点时的处理方式.
+type UnknownUnitPolicy byte
+
+// unknown unit id policies
+const (
+	// UnknownUnitDrop 忽略请求, 不应答.
+	UnknownUnitDrop UnknownUnitPolicy = iota
+	// UnknownUnitTargetFailed 应答异常0x0B gateway target device failed to respond.
+	UnknownUnitTargetFailed
+	// UnknownUnitPathUnavailable 应答异常0x0A gateway path unavailable.
+	UnknownUnitPathUnavailable
+)
 
 type serverCommon struct {
 	node     sync.Map
 	function map[uint8]FunctionHandler
+	// custom 用户注册的功能码, 其回调收到注册的节点本身, 不附加写入来源
+	custom map[uint8]bool
+
+	rw            sync.RWMutex
+	defaultNode   NodeStorage
+	unknownPolicy UnknownUnitPolicy
+	metrics       *serverMetrics
+	// forward 转发请求pdu(含功能码), 返回应答pdu, 设置后不使用本地节点, 网关使用
+	forward func(slaveID byte, pdu []byte) ([]byte, error)
 }
 
 func newServerCommon() *serverCommon {
 	return &serverCommon{
+		metrics: new(serverMetrics),
+		custom:  make(map[uint8]bool),
 		function: map[uint8]FunctionHandler{
 			FuncCodeReadDiscreteInputs:         funcReadDiscreteInputs,
 			FuncCodeReadCoils:                  funcReadCoils,
@@ -41,10 +65,10 @@ func newServerCommon() *serverCommon {
 	}
 }
 
-// AddNodes 增加节点.
-func (sf *serverCommon) AddNodes(nodes ...*NodeRegister) {
+// AddNodes 增加节点, NodeRegister or any NodeStorage implementation.
+func (sf *serverCommon) AddNodes(nodes ...NodeStorage) {
 	for _, v := range nodes {
-		sf.node.Store(v.slaveID, v)
+		sf.node.Store(v.SlaveID(), v)
 	}
 }
 
@@ -61,41 +85,111 @@ func (sf *serverCommon) DeleteAllNode() {
 	})
 }
 
-// GetNode 获取一个节点.
-func (sf *serverCommon) GetNode(slaveID byte) (*NodeRegister, error) {
+// GetStorage 获取一个节点的存储.
+func (sf *serverCommon) GetStorage(slaveID byte) (NodeStorage, error) {
 	v, ok := sf.node.Load(slaveID)
 	if !ok {
 		return nil, errors.New("slaveID not exist")
 	}
-	return v.(*NodeRegister), nil
+	return v.(NodeStorage), nil
 }
 
-// GetNodeList 获取节点列表.
+// GetNode 获取一个节点, 节点存储不是NodeRegister时返回错误.
+func (sf *serverCommon) GetNode(slaveID byte) (*NodeRegister, error) {
+	v, err := sf.GetStorage(slaveID)
+	if err != nil {
+		return nil, err
+	}
+	node, ok := v.(*NodeRegister)
+	if !ok {
+		return nil, errors.New("slaveID storage is not NodeRegister")
+	}
+	return node, nil
+}
+
+// GetNodeList 获取节点列表, 仅含NodeRegister节点.
 func (sf *serverCommon) GetNodeList() []*NodeRegister {
 	list := make([]*NodeRegister, 0)
-	sf.node.Range(func(k, v interface{}) bool {
-		list = append(list, v.(*NodeRegister))
+	sf.Range(func(slaveID byte, node *NodeRegister) bool {
+		list = append(list, node)
 		return true
 	})
 	return list
 }
 
-// Range 扫描节点 same as sync map range.
+// Range 扫描NodeRegister节点 same as sync map range.
 func (sf *serverCommon) Range(f func(slaveID byte, node *NodeRegister) bool) {
 	sf.node.Range(func(k, v interface{}) bool {
-		return f(k.(byte), v.(*NodeRegister))
+		if node, ok := v.(*NodeRegister); ok {
+			return f(k.(byte), node)
+		}
+		return true
+	})
+}
+
+// RangeStorage 扫描所有节点存储 same as sync map range.
+func (sf *serverCommon) RangeStorage(f func(slaveID byte, storage NodeStorage) bool) {
+	sf.node.Range(func(k, v interface{}) bool {
+		return f(k.(byte), v.(NodeStorage))
 	})
 }
 
-// RegisterFunctionHandler 注册回调函数.
+// SetDefaultNode 设置默认节点, 应答所有没有节点的从站地址, nil 取消默认节点.
+func (sf *serverCommon) SetDefaultNode(node NodeStorage) {
+	sf.rw.Lock()
+	sf.defaultNode = node
+	sf.rw.Unlock()
+}
+
+// SetUnknownUnitPolicy 设置请求的从站地址没有节点且没有默认节点时的处理方式,
+// 默认UnknownUnitDrop.
+func (sf *serverCommon) SetUnknownUnitPolicy(p UnknownUnitPolicy) {
+	sf.rw.Lock()
+	sf.unknownPolicy = p
+	sf.rw.Unlock()
+}
+
+// lookupNode 查找从站地址的节点, 没有时使用默认节点, 都没有时按处理方式返回
+// 异常, 返回nil, nil时忽略请求.
+func (sf *serverCommon) lookupNode(slaveID byte) (NodeStorage, error) {
+	if node, err := sf.GetStorage(slaveID); err == nil {
+		return node, nil
+	}
+
+	sf.rw.RLock()
+	node, policy := sf.defaultNode, sf.unknownPolicy
+	sf.rw.RUnlock()
+	switch {
+	case node != nil:
+		return node, nil
+	case policy == UnknownUnitTargetFailed:
+		return nil, &ExceptionError{ExceptionCodeGatewayTargetDeviceFailedToRespond}
+	case policy == UnknownUnitPathUnavailable:
+		return nil, &ExceptionError{ExceptionCodeGatewayPathUnavailable}
+	default:
+		return nil, nil
+	}
+}
+
+// RegisterFunctionHandler 注册回调函数, 回调收到的reg为AddNodes添加的节点本身.
 func (sf *serverCommon) RegisterFunctionHandler(funcCode uint8, function FunctionHandler) {
 	if function != nil {
 		sf.function[funcCode] = function
+		sf.custom[funcCode] = true
+	}
+}
+
+// exceptionCode 错误对应的异常码, 非ExceptionError的错误为从站设备故障.
+func exceptionCode(err error) byte {
+	var e *ExceptionError
+	if errors.As(err, &e) {
+		return e.ExceptionCode
 	}
+	return ExceptionCodeServerDeviceFailure
 }
 
 // readBits 读位寄存器.
-func readBits(reg *NodeRegister, data []byte, isCoil bool) ([]byte, error) {
+func readBits(reg NodeStorage, data []byte, isCoil bool) ([]byte, error) {
 	var value []byte
 	var err error
 
@@ -116,6 +210,9 @@ func readBits(reg *NodeRegister, data []byte, isCoil bool) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(value) != (int(quality)+7)/8 {
+		return nil, &ExceptionError{ExceptionCodeServerDeviceFailure}
+	}
 	result := make([]byte, 0, len(value)+1)
 	result = append(result, byte(len(value)))
 	return append(result, value...), nil
@@ -128,7 +225,7 @@ func readBits(reg *NodeRegister, data []byte, isCoil bool) ([]byte, error) {
 //  return:
 //  Byte count            : 1 bytes
 //  Coils status          : n bytes  n = Quantity/8 or n = Quantity/8 + 1
-func funcReadDiscreteInputs(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcReadDiscreteInputs(reg NodeStorage, data []byte) ([]byte, error) {
 	return readBits(reg, data, false)
 }
 
@@ -139,7 +236,7 @@ func funcReadDiscreteInputs(reg *NodeRegister, data []byte) ([]byte, error) {
 //  return:
 //  Byte count            : 1 bytes
 //  Coils status          : n bytes  n = Quantity/8 or n = Quantity/8 + 1
-func funcReadCoils(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcReadCoils(reg NodeStorage, data []byte) ([]byte, error) {
 	return readBits(reg, data, true)
 }
 
@@ -150,7 +247,7 @@ func funcReadCoils(reg *NodeRegister, data []byte) ([]byte, error) {
 //  return:
 //  Address      		  : 2 byte
 //  Value                 : 2 byte  0xff00 or 0x0000
-func funcWriteSingleCoil(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcWriteSingleCoil(reg NodeStorage, data []byte) ([]byte, error) {
 	if len(data) != FuncWriteMinSize {
 		return nil, &ExceptionError{ExceptionCodeIllegalDataValue}
 	}
@@ -177,7 +274,7 @@ func funcWriteSingleCoil(reg *NodeRegister, data []byte) ([]byte, error) {
 //  return:
 //  Starting address      : 2 byte
 //  Quantity              : 2 byte
-func funcWriteMultiCoils(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcWriteMultiCoils(reg NodeStorage, data []byte) ([]byte, error) {
 	if len(data) < FuncWriteMultiMinSize {
 		return nil, &ExceptionError{ExceptionCodeIllegalDataValue}
 	}
@@ -194,7 +291,7 @@ func funcWriteMultiCoils(reg *NodeRegister, data []byte) ([]byte, error) {
 }
 
 // readRegisters read multi registers.
-func readRegisters(reg *NodeRegister, data []byte, isHolding bool) ([]byte, error) {
+func readRegisters(reg NodeStorage, data []byte, isHolding bool) ([]byte, error) {
 	var err error
 	var value []byte
 
@@ -216,6 +313,9 @@ func readRegisters(reg *NodeRegister, data []byte, isHolding bool) ([]byte, erro
 	if err != nil {
 		return nil, err
 	}
+	if len(value) != int(quality)*2 {
+		return nil, &ExceptionError{ExceptionCodeServerDeviceFailure}
+	}
 	result := make([]byte, 0, len(value)+1)
 	result = append(result, byte(quality*2))
 	result = append(result, value...)
@@ -229,7 +329,7 @@ func readRegisters(reg *NodeRegister, data []byte, isHolding bool) ([]byte, erro
 //  return:
 //  Byte count            : 2 byte  Quantity*2
 //  Value                 : (Quantity)*2 byte
-func funcReadInputRegisters(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcReadInputRegisters(reg NodeStorage, data []byte) ([]byte, error) {
 	return readRegisters(reg, data, false)
 }
 
@@ -240,7 +340,7 @@ func funcReadInputRegisters(reg *NodeRegister, data []byte) ([]byte, error) {
 //  return:
 //  Byte count            : 2 byte  Quantity*2
 //  Value                 : (Quantity)*2 byte
-func funcReadHoldingRegisters(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcReadHoldingRegisters(reg NodeStorage, data []byte) ([]byte, error) {
 	return readRegisters(reg, data, true)
 }
 
@@ -251,7 +351,7 @@ func funcReadHoldingRegisters(reg *NodeRegister, data []byte) ([]byte, error) {
 //  return:
 //  Address            	: 2 byte
 //  Value               : 2 byte
-func funcWriteSingleRegister(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcWriteSingleRegister(reg NodeStorage, data []byte) ([]byte, error) {
 	if len(data) != FuncWriteMinSize {
 		return nil, &ExceptionError{ExceptionCodeIllegalDataValue}
 	}
@@ -270,7 +370,7 @@ func funcWriteSingleRegister(reg *NodeRegister, data []byte) ([]byte, error) {
 //  return:
 //  Starting address      : 2 byte
 //  Quantity              : 2 byte
-func funcWriteMultiHoldingRegisters(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcWriteMultiHoldingRegisters(reg NodeStorage, data []byte) ([]byte, error) {
 	if len(data) < FuncWriteMultiMinSize {
 		return nil, &ExceptionError{ExceptionCodeIllegalDataValue}
 	}
@@ -302,7 +402,7 @@ func funcWriteMultiHoldingRegisters(reg *NodeRegister, data []byte) ([]byte, err
 //  return:
 //  Byte count            : 2 byte  (Quantity read)*2
 //  Value                 : (Quantity read)*2 byte
-func funcReadWriteMultiHoldingRegisters(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcReadWriteMultiHoldingRegisters(reg NodeStorage, data []byte) ([]byte, error) {
 	if len(data) < FuncReadWriteMinSize {
 		return nil, &ExceptionError{ExceptionCodeIllegalDataValue}
 	}
@@ -325,6 +425,9 @@ func funcReadWriteMultiHoldingRegisters(reg *NodeRegister, data []byte) ([]byte,
 	if err != nil {
 		return nil, err
 	}
+	if len(value) != int(readCount)*2 {
+		return nil, &ExceptionError{ExceptionCodeServerDeviceFailure}
+	}
 	result := make([]byte, 0, len(value)+1)
 	result = append(result, byte(readCount*2))
 	result = append(result, value...)
@@ -340,7 +443,7 @@ func funcReadWriteMultiHoldingRegisters(reg *NodeRegister, data []byte) ([]byte,
 //  address				  : 2 byte
 //  And_mask              : 2 byte
 //  Or_mask               : 2 byte
-func funcMaskWriteRegisters(reg *NodeRegister, data []byte) ([]byte, error) {
+func funcMaskWriteRegisters(reg NodeStorage, data []byte) ([]byte, error) {
 	if len(data) != FuncMaskWriteMinSize {
 		return nil, &ExceptionError{ExceptionCodeIllegalDataValue}
 	}
@@ -353,6 +456,6 @@ func funcMaskWriteRegisters(reg *NodeRegister, data []byte) ([]byte, error) {
 }
 
 // TODO funcReadFIFOQueue
-// func (this *ExtraOption)funcReadFIFOQueue(*NodeRegister, []byte) ([]byte, error) {
+// func (this *ExtraOption)funcReadFIFOQueue(NodeStorage, []byte) ([]byte, error) {
 // 	return nil, nil
 // }
@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -335,3 +336,94 @@ func Test_funcMaskWriteRegisters(t *testing.T) {
 		})
 	}
 }
+
+// funcStorage NodeStorage backed by functions, only holding registers.
+type funcStorage struct {
+	NodeStorage
+	slaveID byte
+	read    func(address, quantity uint16) ([]byte, error)
+}
+
+func (sf *funcStorage) SlaveID() byte { return sf.slaveID }
+func (sf *funcStorage) ReadHoldingsBytes(address, quantity uint16) ([]byte, error) {
+	return sf.read(address, quantity)
+}
+
+func Test_serverCommon_storage(t *testing.T) {
+	sc := newServerCommon()
+	sc.AddNodes(newNodeReg(), &funcStorage{
+		slaveID: 0x02,
+		read: func(address, quantity uint16) ([]byte, error) {
+			if address == 0xffff {
+				return nil, errors.New("database unavailable")
+			}
+			return make([]byte, quantity*2), nil
+		},
+	})
+
+	if _, err := sc.GetNode(0x02); err == nil {
+		t.Errorf("GetNode() error = %v, wantErr %v", err, true)
+	}
+	if got := len(sc.GetNodeList()); got != 1 {
+		t.Errorf("GetNodeList() len = %v, want %v", got, 1)
+	}
+	storage, err := sc.GetStorage(0x02)
+	if err != nil {
+		t.Fatalf("GetStorage() error = %v, wantErr %v", err, nil)
+	}
+
+	handle := sc.function[FuncCodeReadHoldingRegisters]
+	got, err := handle(storage, []byte{0x00, 0x10, 0x00, 0x02})
+	if err != nil {
+		t.Fatalf("handler error = %v, wantErr %v", err, nil)
+	}
+	if want := []byte{0x04, 0x00, 0x00, 0x00, 0x00}; !reflect.DeepEqual(got, want) {
+		t.Errorf("handler = %#v, want %#v", got, want)
+	}
+	_, err = handle(storage, []byte{0xff, 0xff, 0x00, 0x01})
+	if code := exceptionCode(err); code != ExceptionCodeServerDeviceFailure {
+		t.Errorf("exceptionCode() = %v, want %v", code, ExceptionCodeServerDeviceFailure)
+	}
+}
+
+func Test_serverCommon_lookupNode(t *testing.T) {
+	sc := newServerCommon()
+	node := newNodeReg()
+	sc.AddNodes(node)
+
+	tests := []struct {
+		name    string
+		policy  UnknownUnitPolicy
+		slaveID byte
+		want    NodeStorage
+		wantErr error
+	}{
+		{"exist", UnknownUnitTargetFailed, 0x01, node, nil},
+		{"drop", UnknownUnitDrop, 0x02, nil, nil},
+		{"target failed", UnknownUnitTargetFailed, 0x02, nil,
+			&ExceptionError{ExceptionCodeGatewayTargetDeviceFailedToRespond}},
+		{"path unavailable", UnknownUnitPathUnavailable, 0x02, nil,
+			&ExceptionError{ExceptionCodeGatewayPathUnavailable}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc.SetUnknownUnitPolicy(tt.policy)
+			got, err := sc.lookupNode(tt.slaveID)
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("lookupNode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("lookupNode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	wildcard := newNodeReg()
+	sc.SetDefaultNode(wildcard)
+	if got, err := sc.lookupNode(0x02); got != wildcard || err != nil {
+		t.Errorf("lookupNode() = %v, %v, want default node", got, err)
+	}
+	if got, _ := sc.lookupNode(0x01); got != node {
+		t.Errorf("lookupNode() = %v, want %v", got, node)
+	}
+}
@@ -142,32 +142,41 @@ type ExceptionError struct {
 
 // Error converts known modbus exception code to error message.
 func (e *ExceptionError) Error() string {
-	var name string
-	switch e.ExceptionCode {
+	return fmt.Sprintf("modbus: exception '%v' (%s)", e.ExceptionCode, exceptionName(e.ExceptionCode))
+}
+
+// exceptionName returns the name of the exception code.
+func exceptionName(code byte) string {
+	switch code {
 	case ExceptionCodeIllegalFunction:
-		name = "illegal function"
+		return "illegal function"
 	case ExceptionCodeIllegalDataAddress:
-		name = "illegal data address"
+		return "illegal data address"
 	case ExceptionCodeIllegalDataValue:
-		name = "illegal data value"
+		return "illegal data value"
 	case ExceptionCodeServerDeviceFailure:
-		name = "server device failure"
+		return "server device failure"
 	case ExceptionCodeAcknowledge:
-		name = "acknowledge"
+		return "acknowledge"
 	case ExceptionCodeServerDeviceBusy:
-		name = "server device busy"
+		return "server device busy"
 	case ExceptionCodeNegativeAcknowledge:
-		name = "Negative Acknowledge"
+		return "Negative Acknowledge"
 	case ExceptionCodeMemoryParityError:
-		name = "memory parity error"
+		return "memory parity error"
 	case ExceptionCodeGatewayPathUnavailable:
-		name = "gateway path unavailable"
+		return "gateway path unavailable"
 	case ExceptionCodeGatewayTargetDeviceFailedToRespond:
-		name = "gateway target device failed to respond"
+		return "gateway target device failed to respond"
 	default:
-		name = "unknown"
+		return "unknown"
 	}
-	return fmt.Sprintf("modbus: exception '%v' (%s)", e.ExceptionCode, name)
+}
+
+// Is reports whether target is an ExceptionError with the same exception code.
+func (e *ExceptionError) Is(target error) bool {
+	t, ok := target.(*ExceptionError)
+	return ok && t.ExceptionCode == e.ExceptionCode
 }
 
 // protocolTCPHeader independent of underlying communication layers.
@@ -214,6 +223,18 @@ type ClientProvider interface {
 	setSerialConfig(config serial.Config)
 	// setTCPTimeout set tcp connect & read timeout
 	setTCPTimeout(t time.Duration)
+	// setFrameSilence enable rtu silence based frame reception
+	setFrameSilence(t35 time.Duration)
+	// setTurnaroundDelay set serial turnaround delay after broadcast
+	setTurnaroundDelay(t time.Duration)
+	// addCustomFunction declare user defined functions
+	addCustomFunction(fns ...CustomFunction)
+	// setCapture set the sink capturing sent and received frames
+	setCapture(sink CaptureSink)
+	// setDialer set the tcp connection dialer
+	setDialer(dial DialFunc)
+	// setPortOpener set the serial port opener
+	setPortOpener(open PortOpenFunc)
 }
 
 // LogProvider RFC5424 log message levels only Debug and Error
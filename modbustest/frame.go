@@ -0,0 +1,138 @@
+package modbustest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	modbus "github.com/things-go/go-modbus"
+)
+
+// errInvalidFrame the frame is corrupted, ignore it like a real device
+var errInvalidFrame = errors.New("modbustest: invalid frame")
+
+const (
+	tcpHeaderSize = 7
+	rtuMaxSize    = 256
+)
+
+// frame a request frame received from the client
+type frame struct {
+	adu     []byte
+	tid     uint16 // tcp transaction id
+	slaveID byte
+	pdu     []byte
+}
+
+// frameReader 按传输方式读取请求帧
+type frameReader struct {
+	transport modbus.Transport
+	r         io.Reader
+	br        *bufio.Reader // ascii only
+}
+
+func newFrameReader(transport modbus.Transport, r io.Reader) *frameReader {
+	sf := &frameReader{transport: transport, r: r}
+	if transport == modbus.TransportASCII {
+		sf.br = bufio.NewReader(r)
+	}
+	return sf
+}
+
+// read the next frame, errInvalidFrame means skip it and read the next.
+func (sf *frameReader) read() (*frame, error) {
+	switch sf.transport {
+	case modbus.TransportRTU:
+		return sf.readRTU()
+	case modbus.TransportASCII:
+		return sf.readASCII()
+	default:
+		return sf.readTCP()
+	}
+}
+
+func (sf *frameReader) readTCP() (*frame, error) {
+	adu := make([]byte, tcpHeaderSize)
+	if _, err := io.ReadFull(sf.r, adu); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(adu[4:]))
+	if length < 2 {
+		return nil, errInvalidFrame
+	}
+	adu = append(adu, make([]byte, length-1)...)
+	if _, err := io.ReadFull(sf.r, adu[tcpHeaderSize:]); err != nil {
+		return nil, err
+	}
+	return &frame{adu, binary.BigEndian.Uint16(adu), adu[6], adu[tcpHeaderSize:]}, nil
+}
+
+// readRTU the client writes a frame at once, so that a read is a frame.
+func (sf *frameReader) readRTU() (*frame, error) {
+	adu := make([]byte, rtuMaxSize)
+	n, err := sf.r.Read(adu)
+	if err != nil {
+		return nil, err
+	}
+	adu = adu[:n]
+	if n < 4 || modbus.CRC16(adu[:n-2]) != binary.LittleEndian.Uint16(adu[n-2:]) {
+		return nil, errInvalidFrame
+	}
+	return &frame{adu: adu, slaveID: adu[0], pdu: adu[1 : n-2]}, nil
+}
+
+func (sf *frameReader) readASCII() (*frame, error) {
+	adu, err := sf.br.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	line := bytes.TrimSuffix(adu, []byte("\r\n"))
+	if len(line) == len(adu) || len(line) < 1 || line[0] != ':' {
+		return nil, errInvalidFrame
+	}
+	data := make([]byte, hex.DecodedLen(len(line)-1))
+	if _, err = hex.Decode(data, line[1:]); err != nil || len(data) < 3 {
+		return nil, errInvalidFrame
+	}
+	if new(modbus.LRC).Push(data[:len(data)-1]...).Value() != data[len(data)-1] {
+		return nil, errInvalidFrame
+	}
+	return &frame{adu: adu, slaveID: data[0], pdu: data[1 : len(data)-1]}, nil
+}
+
+// encode the response pdu to the adu of transport, corrupt means a wrong
+// checksum of serial or a wrong protocol identifier of tcp.
+func encode(transport modbus.Transport, request *frame, pdu []byte, corrupt bool) []byte {
+	switch transport {
+	case modbus.TransportRTU:
+		adu := append([]byte{request.slaveID}, pdu...)
+		crc := modbus.CRC16(adu)
+		if corrupt {
+			crc = ^crc
+		}
+		return append(adu, byte(crc), byte(crc>>8))
+	case modbus.TransportASCII:
+		data := append([]byte{request.slaveID}, pdu...)
+		lrc := new(modbus.LRC).Push(data...).Value()
+		if corrupt {
+			lrc = ^lrc
+		}
+		data = append(data, lrc)
+		adu := make([]byte, 1, 3+hex.EncodedLen(len(data)))
+		adu[0] = ':'
+		adu = append(adu, bytes.ToUpper([]byte(hex.EncodeToString(data)))...)
+		return append(adu, '\r', '\n')
+	default:
+		adu := make([]byte, tcpHeaderSize, tcpHeaderSize+len(pdu))
+		binary.BigEndian.PutUint16(adu, request.tid)
+		if corrupt {
+			binary.BigEndian.PutUint16(adu[2:], 0xffff)
+		}
+		binary.BigEndian.PutUint16(adu[4:], uint16(1+len(pdu)))
+		adu[6] = request.slaveID
+		return append(adu, pdu...)
+	}
+}
@@ -0,0 +1,129 @@
+package modbustest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// errServerClosed dial or open after the server closed
+var errServerClosed = errors.New("modbustest: server closed")
+
+// pipeAddr the address of the in memory connections
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "modbustest" }
+
+// pipeListener 内存中的net.Listener, dial的连接以net.Pipe的一端交给Accept
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept implements net.Listener
+func (sf *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-sf.conns:
+		return conn, nil
+	case <-sf.done:
+		return nil, errServerClosed
+	}
+}
+
+// Close implements net.Listener
+func (sf *pipeListener) Close() error {
+	sf.once.Do(func() { close(sf.done) })
+	return nil
+}
+
+// Addr implements net.Listener
+func (sf *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+// dial returns the client end of a new connection accepted by Accept
+func (sf *pipeListener) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case sf.conns <- server:
+		return client, nil
+	case <-sf.done:
+		client.Close()
+		server.Close()
+		return nil, errServerClosed
+	}
+}
+
+// nullModem 内存中串口零调制解调器的一端, 写入的数据从另一端读出,
+// 读超时返回serial.ErrTimeout, 与串口一样.
+type nullModem struct {
+	rx      <-chan []byte
+	tx      chan<- []byte
+	pending []byte
+	timeout time.Duration // 0 means wait forever
+	done    chan struct{} // closed by either end
+	once    *sync.Once
+}
+
+// newNullModem returns the two ends of a null modem cable, the read timeout
+// of the first end is timeout, the second end waits forever.
+func newNullModem(timeout time.Duration) (*nullModem, *nullModem) {
+	a2b, b2a := make(chan []byte, 64), make(chan []byte, 64)
+	done, once := make(chan struct{}), new(sync.Once)
+	return &nullModem{rx: b2a, tx: a2b, timeout: timeout, done: done, once: once},
+		&nullModem{rx: a2b, tx: b2a, done: done, once: once}
+}
+
+// Read implements io.Reader, returns at most the data of one write.
+func (sf *nullModem) Read(p []byte) (int, error) {
+	if len(sf.pending) == 0 {
+		var expired <-chan time.Time
+		if sf.timeout > 0 {
+			timer := time.NewTimer(sf.timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case sf.pending = <-sf.rx:
+		case <-expired:
+			return 0, serial.ErrTimeout
+		case <-sf.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, sf.pending)
+	sf.pending = sf.pending[n:]
+	return n, nil
+}
+
+// Write implements io.Writer
+func (sf *nullModem) Write(p []byte) (int, error) {
+	select {
+	case <-sf.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	select {
+	case sf.tx <- append([]byte(nil), p...):
+		return len(p), nil
+	case <-sf.done:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+// Close implements io.Closer, closes both ends.
+func (sf *nullModem) Close() error {
+	sf.once.Do(func() { close(sf.done) })
+	return nil
+}
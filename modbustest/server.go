@@ -0,0 +1,460 @@
+// Package modbustest provides utilities for modbus testing, like net/http/httptest.
+//
+// Server is an in memory modbus server preloaded with nodes, the providers it
+// returns are connected to it by net.Pipe(tcp) or an in memory null modem
+// cable(rtu and ascii), no socket or serial port is used. A tcp connection is
+// served by the embedded modbus.TCPServer as it is, with all its settings.
+// It can inject latency and faults, and records the received requests for
+// assertions.
+package modbustest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+	modbus "github.com/things-go/go-modbus"
+)
+
+// Request a request received by the server
+type Request struct {
+	Time      time.Time
+	Transport modbus.Transport
+	SlaveID   byte
+	FuncCode  byte
+	Address   uint16   // the address of the request, see modbus.Dissection
+	Quantity  uint16   // the quantity of the request, see modbus.Dissection
+	Values    []uint16 // the values to write
+	PDU       []byte
+	ADU       []byte
+}
+
+// Fault injected to a request
+type Fault struct {
+	// Delay delay the response besides the latency
+	Delay time.Duration
+	// NoResponse drop the request without response, the client times out.
+	NoResponse bool
+	// Exception reply the exception without handling the request, 0 means not.
+	Exception byte
+	// Corrupt reply a corrupted response, the crc of rtu, the lrc of ascii,
+	// or the protocol identifier of tcp is wrong.
+	Corrupt bool
+}
+
+// Server an in memory modbus server, the nodes are served by the embedded
+// TCPServer. A tcp connection is served by it directly, the requests of rtu
+// and ascii are relayed to it.
+type Server struct {
+	*modbus.TCPServer
+	listener *pipeListener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	latency  time.Duration
+	fault    func(req Request) Fault
+	requests []Request
+	conns    map[io.Closer]struct{} // the server end of the client connections
+	closed   bool
+}
+
+// NewServer starts and returns a new in memory server serving the nodes.
+// The caller should call Close when finished, to shut it down.
+func NewServer(nodes ...modbus.NodeStorage) *Server {
+	sf := &Server{
+		TCPServer: modbus.NewTCPServer(),
+		listener:  newPipeListener(),
+		conns:     make(map[io.Closer]struct{}),
+	}
+	sf.AddNodes(nodes...)
+	sf.wg.Add(1)
+	go func() {
+		defer sf.wg.Done()
+		_ = sf.TCPServer.Serve(sf.listener)
+	}()
+	return sf
+}
+
+// TCPProvider returns a tcp provider connected to the server by net.Pipe.
+func (sf *Server) TCPProvider(opts ...modbus.ClientProviderOption) *modbus.TCPClientProvider {
+	p := modbus.NewTCPClientProvider(pipeAddr{}.String(), append(opts, modbus.WithDialer(sf.dial))...)
+	connect(p)
+	return p
+}
+
+// RTUProvider returns a rtu provider connected to the server by an in memory
+// null modem cable, the read timeout is the serial config timeout, default
+// modbus.SerialDefaultTimeout. A request the nodes do not respond, such as of
+// an unknown slave, is not responded after the timeout.
+func (sf *Server) RTUProvider(opts ...modbus.ClientProviderOption) *modbus.RTUClientProvider {
+	p := modbus.NewRTUClientProvider(append(opts, modbus.WithPortOpener(sf.opener(modbus.TransportRTU)))...)
+	connect(p)
+	return p
+}
+
+// ASCIIProvider returns an ascii provider connected to the server by an in
+// memory null modem cable, see RTUProvider.
+func (sf *Server) ASCIIProvider(opts ...modbus.ClientProviderOption) *modbus.ASCIIClientProvider {
+	p := modbus.NewASCIIClientProvider(append(opts, modbus.WithPortOpener(sf.opener(modbus.TransportASCII)))...)
+	connect(p)
+	return p
+}
+
+// SetLatency delay every response, default 0.
+func (sf *Server) SetLatency(d time.Duration) *Server {
+	sf.mu.Lock()
+	sf.latency = d
+	sf.mu.Unlock()
+	return sf
+}
+
+// SetFault set the fault injected to every request, fn is called after the
+// request recorded, nil means no fault.
+func (sf *Server) SetFault(fn func(req Request) Fault) *Server {
+	sf.mu.Lock()
+	sf.fault = fn
+	sf.mu.Unlock()
+	return sf
+}
+
+// Requests returns the received requests in order.
+func (sf *Server) Requests() []Request {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return append([]Request(nil), sf.requests...)
+}
+
+// ResetRequests clear the received requests.
+func (sf *Server) ResetRequests() {
+	sf.mu.Lock()
+	sf.requests = nil
+	sf.mu.Unlock()
+}
+
+// ExpectRequests reports a test error if the received requests are not want,
+// SlaveID, FuncCode, Address and Quantity are compared, Values are compared
+// only if it is not nil in want.
+func (sf *Server) ExpectRequests(t testing.TB, want ...Request) {
+	t.Helper()
+	got := sf.Requests()
+	if len(got) != len(want) {
+		t.Errorf("modbustest: received %d requests, want %d", len(got), len(want))
+		return
+	}
+	for i := range want {
+		if !matchRequest(got[i], want[i]) {
+			t.Errorf("modbustest: request %d is %s, want %s", i, requestString(got[i]), requestString(want[i]))
+		}
+	}
+}
+
+// Close shuts down the server and blocks until all the connections closed.
+func (sf *Server) Close() {
+	sf.mu.Lock()
+	sf.closed = true
+	for conn := range sf.conns {
+		conn.Close()
+	}
+	sf.mu.Unlock()
+	_ = sf.TCPServer.Close()
+	sf.listener.Close()
+	sf.wg.Wait()
+}
+
+// connect panics if failed, like httptest
+func connect(p modbus.ClientProvider) {
+	if err := p.Connect(); err != nil {
+		panic(fmt.Sprintf("modbustest: failed to connect: %v", err))
+	}
+}
+
+// dial implements modbus.DialFunc, the TCPServer serves the server end.
+func (sf *Server) dial(_, _ string) (net.Conn, error) {
+	client, server := net.Pipe()
+	conn := newFaultConn(sf, server)
+	if !sf.track(server, func() { _ = sf.ServeConn(conn) }) {
+		client.Close()
+		return nil, errServerClosed
+	}
+	return client, nil
+}
+
+// opener returns the modbus.PortOpenFunc of transport
+func (sf *Server) opener(transport modbus.Transport) modbus.PortOpenFunc {
+	return func(config *serial.Config) (io.ReadWriteCloser, error) {
+		timeout := config.Timeout
+		if timeout <= 0 {
+			timeout = modbus.SerialDefaultTimeout
+		}
+		client, server := newNullModem(timeout)
+		if !sf.track(server, func() { sf.serve(transport, server, timeout) }) {
+			return nil, errServerClosed
+		}
+		return client, nil
+	}
+}
+
+// track run serve for the server end of a client connection
+func (sf *Server) track(conn io.Closer, serve func()) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.closed {
+		conn.Close()
+		return false
+	}
+	sf.conns[conn] = struct{}{}
+	sf.wg.Add(1)
+	go func() {
+		defer func() {
+			conn.Close()
+			sf.mu.Lock()
+			delete(sf.conns, conn)
+			sf.mu.Unlock()
+			sf.wg.Done()
+		}()
+		serve()
+	}()
+	return true
+}
+
+// serve relay the requests of a serial client connection to the nodes,
+// waits the response of the nodes at most timeout.
+func (sf *Server) serve(transport modbus.Transport, conn io.ReadWriteCloser, timeout time.Duration) {
+	b := &backend{listener: sf.listener, timeout: timeout}
+	defer b.close()
+
+	reader := newFrameReader(transport, conn)
+	for {
+		request, err := reader.read()
+		if err == errInvalidFrame {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		latency, fault := sf.receive(transport, request)
+		time.Sleep(latency + fault.Delay)
+		if fault.NoResponse {
+			continue
+		}
+		response, err := sf.respond(b, request, fault)
+		if err != nil {
+			return
+		}
+		if response == nil {
+			continue
+		}
+		if _, err = conn.Write(encode(transport, request, response, fault.Corrupt)); err != nil {
+			return
+		}
+	}
+}
+
+// faultConn the server end of a tcp client connection served by the TCPServer,
+// it records the requests and injects the faults between them.
+type faultConn struct {
+	net.Conn
+	server  *Server
+	reader  *frameReader
+	pending []byte // the request passed to the TCPServer but not read yet
+
+	mu      sync.Mutex      // serializes the injected and the TCPServer writes
+	corrupt map[uint16]bool // transaction id of the responses to be corrupted
+}
+
+func newFaultConn(server *Server, conn net.Conn) *faultConn {
+	return &faultConn{
+		Conn:    conn,
+		server:  server,
+		reader:  newFrameReader(modbus.TransportTCP, conn),
+		corrupt: make(map[uint16]bool),
+	}
+}
+
+// Read implements net.Conn, returns the requests to the TCPServer, the one
+// dropped or answered by the fault is not returned.
+func (sf *faultConn) Read(p []byte) (int, error) {
+	for len(sf.pending) == 0 {
+		request, err := sf.reader.read()
+		if err == errInvalidFrame {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		latency, fault := sf.server.receive(modbus.TransportTCP, request)
+		time.Sleep(latency + fault.Delay)
+		switch {
+		case fault.NoResponse:
+		case fault.Exception != 0:
+			response := []byte{request.pdu[0] | 0x80, fault.Exception}
+			sf.mu.Lock()
+			_, err = sf.Conn.Write(encode(modbus.TransportTCP, request, response, fault.Corrupt))
+			sf.mu.Unlock()
+			if err != nil {
+				return 0, err
+			}
+		default:
+			if fault.Corrupt {
+				sf.mu.Lock()
+				sf.corrupt[request.tid] = true
+				sf.mu.Unlock()
+			}
+			sf.pending = request.adu
+		}
+	}
+	n := copy(p, sf.pending)
+	sf.pending = sf.pending[n:]
+	return n, nil
+}
+
+// Write implements net.Conn, corrupts the protocol identifier of the response
+// if its request is to be corrupted.
+func (sf *faultConn) Write(b []byte) (int, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if len(b) >= tcpHeaderSize {
+		if tid := binary.BigEndian.Uint16(b); sf.corrupt[tid] {
+			delete(sf.corrupt, tid)
+			b = append([]byte(nil), b...)
+			binary.BigEndian.PutUint16(b[2:], 0xffff)
+		}
+	}
+	return sf.Conn.Write(b)
+}
+
+// receive record the request, returns the latency and the fault of it
+func (sf *Server) receive(transport modbus.Transport, request *frame) (time.Duration, Fault) {
+	d := modbus.Dissect(transport, modbus.DirectionRequest, request.adu)
+	req := Request{
+		Time:      time.Now(),
+		Transport: transport,
+		SlaveID:   request.slaveID,
+		FuncCode:  request.pdu[0],
+		Address:   d.Address,
+		Quantity:  d.Quantity,
+		Values:    d.Values,
+		PDU:       request.pdu,
+		ADU:       request.adu,
+	}
+	sf.mu.Lock()
+	sf.requests = append(sf.requests, req)
+	latency, fn := sf.latency, sf.fault
+	sf.mu.Unlock()
+	var fault Fault
+	if fn != nil {
+		fault = fn(req)
+	}
+	return latency, fault
+}
+
+// respond returns the response pdu of the request, nil means no response
+func (sf *Server) respond(b *backend, request *frame, fault Fault) ([]byte, error) {
+	switch {
+	case fault.Exception != 0:
+		return []byte{request.pdu[0] | 0x80, fault.Exception}, nil
+	case request.slaveID == modbus.AddressBroadCast:
+		var slaveIDs []byte
+		sf.RangeStorage(func(slaveID byte, _ modbus.NodeStorage) bool {
+			slaveIDs = append(slaveIDs, slaveID)
+			return true
+		})
+		for _, slaveID := range slaveIDs {
+			if _, err := b.exchange(slaveID, request.pdu); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	default:
+		return b.exchange(request.slaveID, request.pdu)
+	}
+}
+
+// backend 一个客户端连接到TCPServer的连接, 断开时重新连接
+type backend struct {
+	listener *pipeListener
+	timeout  time.Duration // wait the response, the timeout of the client
+	conn     net.Conn
+	tid      uint16
+}
+
+// exchange send the request pdu to the nodes, returns the response pdu,
+// nil means no response.
+func (sf *backend) exchange(slaveID byte, pdu []byte) ([]byte, error) {
+	for retry := 0; ; retry++ {
+		if sf.conn == nil {
+			conn, err := sf.listener.dial()
+			if err != nil {
+				return nil, err
+			}
+			sf.conn = conn
+		}
+		response, err := sf.roundTrip(slaveID, pdu)
+		if err == nil {
+			return response, nil
+		}
+		sf.close()
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return nil, nil
+		}
+		if retry > 0 { // the session closed by the server since idle, retry once
+			return nil, err
+		}
+	}
+}
+
+func (sf *backend) roundTrip(slaveID byte, pdu []byte) ([]byte, error) {
+	sf.tid++
+	request := encode(modbus.TransportTCP, &frame{tid: sf.tid, slaveID: slaveID}, pdu, false)
+	if err := sf.conn.SetDeadline(time.Now().Add(sf.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := sf.conn.Write(request); err != nil {
+		return nil, err
+	}
+	for {
+		response, err := newFrameReader(modbus.TransportTCP, sf.conn).read()
+		if err != nil {
+			return nil, err
+		}
+		if response.tid == sf.tid && binary.BigEndian.Uint16(response.adu[2:]) == 0 {
+			return response.pdu, nil
+		}
+	}
+}
+
+func (sf *backend) close() {
+	if sf.conn != nil {
+		sf.conn.Close()
+		sf.conn = nil
+	}
+}
+
+func matchRequest(got, want Request) bool {
+	if got.SlaveID != want.SlaveID || got.FuncCode != want.FuncCode ||
+		got.Address != want.Address || got.Quantity != want.Quantity {
+		return false
+	}
+	if want.Values == nil {
+		return true
+	}
+	if len(got.Values) != len(want.Values) {
+		return false
+	}
+	for i := range want.Values {
+		if got.Values[i] != want.Values[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func requestString(r Request) string {
+	return fmt.Sprintf("{slave %d func %d address %d quantity %d values %v}",
+		r.SlaveID, r.FuncCode, r.Address, r.Quantity, r.Values)
+}
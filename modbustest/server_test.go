@@ -0,0 +1,157 @@
+package modbustest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+	modbus "github.com/things-go/go-modbus"
+)
+
+func newTestServer() *Server {
+	return NewServer(
+		modbus.NewNodeRegister(0x01, 0, 10, 0, 10, 0, 10, 0, 10),
+		modbus.NewNodeRegister(0x02, 0, 10, 0, 10, 0, 10, 0, 10),
+	)
+}
+
+func TestServer(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	providers := map[string]modbus.ClientProvider{
+		"tcp":   srv.TCPProvider(),
+		"rtu":   srv.RTUProvider(),
+		"ascii": srv.ASCIIProvider(),
+	}
+	for name, p := range providers {
+		t.Run(name, func(t *testing.T) {
+			srv.ResetRequests()
+			client := modbus.NewClient(p)
+			defer client.Close()
+			if err := client.WriteMultipleRegisters(0x01, 2, 2, []uint16{0x1234, 0x5678}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := client.ReadHoldingRegisters(0x01, 2, 2)
+			if err != nil || !reflect.DeepEqual(got, []uint16{0x1234, 0x5678}) {
+				t.Errorf("ReadHoldingRegisters() = %v, %v, want %v", got, err, []uint16{0x1234, 0x5678})
+			}
+			if _, err = client.ReadHoldingRegisters(0x01, 20, 1); !errors.Is(err, &modbus.ExceptionError{ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}) {
+				t.Errorf("ReadHoldingRegisters() error = %v, want exception %d", err, modbus.ExceptionCodeIllegalDataAddress)
+			}
+			srv.ExpectRequests(t,
+				Request{SlaveID: 0x01, FuncCode: modbus.FuncCodeWriteMultipleRegisters, Address: 2, Quantity: 2, Values: []uint16{0x1234, 0x5678}},
+				Request{SlaveID: 0x01, FuncCode: modbus.FuncCodeReadHoldingRegisters, Address: 2, Quantity: 2},
+				Request{SlaveID: 0x01, FuncCode: modbus.FuncCodeReadHoldingRegisters, Address: 20, Quantity: 1},
+			)
+		})
+	}
+}
+
+func TestServer_fault(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+
+	const timeout = 50 * time.Millisecond
+	providers := map[string]modbus.ClientProvider{
+		"tcp":   srv.TCPProvider(modbus.WithTCPTimeout(timeout)),
+		"rtu":   srv.RTUProvider(modbus.WithSerialConfig(serial.Config{Timeout: timeout})),
+		"ascii": srv.ASCIIProvider(modbus.WithSerialConfig(serial.Config{Timeout: timeout})),
+	}
+	for name, p := range providers {
+		t.Run(name, func(t *testing.T) {
+			client := modbus.NewClient(p)
+			defer client.Close()
+
+			srv.SetFault(func(Request) Fault { return Fault{Exception: modbus.ExceptionCodeServerDeviceBusy} })
+			if _, err := client.ReadCoils(0x01, 0, 8); !errors.Is(err, &modbus.ExceptionError{ExceptionCode: modbus.ExceptionCodeServerDeviceBusy}) {
+				t.Errorf("ReadCoils() error = %v, want exception %d", err, modbus.ExceptionCodeServerDeviceBusy)
+			}
+			srv.SetFault(func(Request) Fault { return Fault{Corrupt: true} })
+			if _, err := client.ReadCoils(0x01, 0, 8); err == nil {
+				t.Errorf("ReadCoils() corrupted error = %v, wantErr %v", err, true)
+			}
+			srv.SetFault(func(Request) Fault { return Fault{NoResponse: true} })
+			if _, err := client.ReadCoils(0x01, 0, 8); err == nil {
+				t.Errorf("ReadCoils() no response error = %v, wantErr %v", err, true)
+			}
+			srv.SetFault(nil)
+			if _, err := client.ReadCoils(0x03, 0, 8); err == nil { // unknown slave
+				t.Errorf("ReadCoils() unknown slave error = %v, wantErr %v", err, true)
+			}
+			if _, err := client.ReadCoils(0x01, 0, 8); err != nil {
+				t.Errorf("ReadCoils() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestServer_latency(t *testing.T) {
+	srv := newTestServer().SetLatency(30 * time.Millisecond)
+	defer srv.Close()
+	client := modbus.NewClient(srv.TCPProvider())
+	defer client.Close()
+
+	start := time.Now()
+	if _, err := client.ReadCoils(0x01, 0, 8); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("ReadCoils() elapsed %v, want at least %v", elapsed, 30*time.Millisecond)
+	}
+}
+
+func TestServer_broadcast(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+	client := modbus.NewClient(srv.RTUProvider(modbus.WithTurnaroundDelay(10 * time.Millisecond)))
+	defer client.Close()
+
+	if err := client.WriteSingleRegister(modbus.AddressBroadCast, 0, 0x55aa); err != nil {
+		t.Fatal(err)
+	}
+	for _, slaveID := range []byte{0x01, 0x02} {
+		got, err := client.ReadHoldingRegisters(slaveID, 0, 1)
+		if err != nil || got[0] != 0x55aa {
+			t.Errorf("slave %d ReadHoldingRegisters() = %v, %v, want %v", slaveID, got, err, 0x55aa)
+		}
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	srv := newTestServer()
+	client := modbus.NewClient(srv.TCPProvider(modbus.WithTCPTimeout(50 * time.Millisecond)))
+	srv.Close()
+	if _, err := client.ReadCoils(0x01, 0, 8); err == nil {
+		t.Errorf("ReadCoils() after Close error = %v, wantErr %v", err, true)
+	}
+	client.Close()
+	if err := client.Connect(); err == nil {
+		t.Errorf("Connect() after Close error = %v, wantErr %v", err, true)
+	}
+}
+
+func TestServer_tcpServerSettings(t *testing.T) {
+	srv := newTestServer()
+	defer srv.Close()
+	// the tcp connections are served by the TCPServer with its settings
+	srv.SetUnknownUnitPolicy(modbus.UnknownUnitPathUnavailable)
+	srv.SetSessionRateLimit(0.001, 2)
+	client := modbus.NewClient(srv.TCPProvider(modbus.WithTCPTimeout(time.Second)))
+	defer client.Close()
+
+	if _, err := client.ReadCoils(0x03, 0, 8); !errors.Is(err, &modbus.ExceptionError{ExceptionCode: modbus.ExceptionCodeGatewayPathUnavailable}) {
+		t.Errorf("ReadCoils() unknown slave error = %v, want exception %d", err, modbus.ExceptionCodeGatewayPathUnavailable)
+	}
+	if _, err := client.ReadCoils(0x01, 0, 8); err != nil {
+		t.Errorf("ReadCoils() error = %v", err)
+	}
+	if _, err := client.ReadCoils(0x01, 0, 8); !errors.Is(err, &modbus.ExceptionError{ExceptionCode: modbus.ExceptionCodeServerDeviceBusy}) {
+		t.Errorf("ReadCoils() over rate limit error = %v, want exception %d", err, modbus.ExceptionCodeServerDeviceBusy)
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Errorf("Requests() = %d, want %d", n, 3)
+	}
+}
@@ -0,0 +1,203 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// pcap link types
+const (
+	// LinkTypeRaw raw ip packet, modbus tcp frames with synthesized tcp/ip headers.
+	LinkTypeRaw = 101
+	// LinkTypeUser0 serial frames as they are, configure DLT_USER 147 as
+	// mbrtu in wireshark to decode the rtu frames.
+	LinkTypeUser0 = 147
+)
+
+const (
+	pcapMagic    = 0xa1b2c3d4
+	pcapSnapLen  = 65535
+	tcpWindow    = 65535
+	tcpFlagsPush = 0x18 // PSH | ACK
+)
+
+// PcapWriter 将捕获的帧以经典pcap格式(非pcapng)写入, 实现CaptureSink, 并发安全.
+// modbus tcp帧合成IPv4或IPv6及TCP头, 链路类型LinkTypeRaw; 串口(rtu或ascii)
+// 帧原样写入, 链路类型LinkTypeUser0. 一个文件只有一种传输方式, 与之不符的帧被忽略.
+type PcapWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	transport Transport
+	seq       map[string]uint32 // next sequence number of flow "src>dst"
+	err       error
+}
+
+// NewPcapWriter new pcap writer for the frames of transport, writes the pcap
+// file header to w immediately.
+func NewPcapWriter(w io.Writer, transport Transport) (*PcapWriter, error) {
+	sf := &PcapWriter{
+		w:         w,
+		transport: transport,
+		seq:       make(map[string]uint32),
+	}
+	linkType := uint32(LinkTypeRaw)
+	if transport != TransportTCP {
+		linkType = LinkTypeUser0
+	}
+	var header [24]byte
+	binary.LittleEndian.PutUint32(header[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:], 2) // version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], linkType)
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// CreatePcapFile create the named pcap file, see NewPcapWriter.
+func CreatePcapFile(name string, transport Transport) (*PcapWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	sf, err := NewPcapWriter(f, transport)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return sf, nil
+}
+
+// Capture implements CaptureSink, writes the frame as a packet.
+func (sf *PcapWriter) Capture(frame CapturedFrame) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.err != nil || frame.Transport != sf.transport {
+		return
+	}
+
+	packet := frame.ADU
+	if sf.transport == TransportTCP {
+		packet = sf.tcpPacket(frame)
+	}
+	var header [16]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(frame.Time.Unix()))
+	binary.LittleEndian.PutUint32(header[4:], uint32(frame.Time.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(packet)))
+	if _, sf.err = sf.w.Write(header[:]); sf.err == nil {
+		_, sf.err = sf.w.Write(packet)
+	}
+}
+
+// Err returns the first write error, the writer stops writing after it.
+func (sf *PcapWriter) Err() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.err
+}
+
+// Close close the underlying writer if it is an io.Closer.
+func (sf *PcapWriter) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.err == nil {
+		sf.err = errors.New("modbus: pcap writer closed")
+	}
+	if c, ok := sf.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// tcpPacket 为modbus tcp帧合成ip和tcp头, 按流维护序列号.
+// Caller must hold the mutex before calling this method.
+func (sf *PcapWriter) tcpPacket(frame CapturedFrame) []byte {
+	srcIP, srcPort := tcpEndpoint(frame.LocalAddr)
+	dstIP, dstPort := tcpEndpoint(frame.RemoteAddr)
+	if !frame.Sent {
+		srcIP, srcPort, dstIP, dstPort = dstIP, dstPort, srcIP, srcPort
+	}
+	src := net.JoinHostPort(srcIP.String(), strconv.Itoa(int(srcPort)))
+	dst := net.JoinHostPort(dstIP.String(), strconv.Itoa(int(dstPort)))
+	flow, reverse := src+">"+dst, dst+">"+src
+	seq, ok := sf.seq[flow]
+	if !ok {
+		seq = 1
+	}
+	ack, ok := sf.seq[reverse]
+	if !ok {
+		ack = 1
+	}
+	sf.seq[flow] = seq + uint32(len(frame.ADU))
+
+	segment := make([]byte, 20+len(frame.ADU))
+	binary.BigEndian.PutUint16(segment[0:], srcPort)
+	binary.BigEndian.PutUint16(segment[2:], dstPort)
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	binary.BigEndian.PutUint32(segment[8:], ack)
+	segment[12] = 5 << 4 // data offset
+	segment[13] = tcpFlagsPush
+	binary.BigEndian.PutUint16(segment[14:], tcpWindow)
+	copy(segment[20:], frame.ADU)
+
+	var packet, pseudo []byte
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		packet = make([]byte, 20, 20+len(segment))
+		packet[0] = 0x45 // version 4, header length 20
+		binary.BigEndian.PutUint16(packet[2:], uint16(20+len(segment)))
+		packet[6] = 0x40 // don't fragment
+		packet[8] = 64   // ttl
+		packet[9] = 6    // tcp
+		copy(packet[12:], src4)
+		copy(packet[16:], dst4)
+		binary.BigEndian.PutUint16(packet[10:], foldIPChecksum(ipChecksum(0, packet)))
+		pseudo = append(append(append([]byte(nil), src4...), dst4...), 0, 6, 0, 0)
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(segment)))
+	} else {
+		packet = make([]byte, 40, 40+len(segment))
+		packet[0] = 0x60 // version 6
+		binary.BigEndian.PutUint16(packet[4:], uint16(len(segment)))
+		packet[6] = 6  // tcp
+		packet[7] = 64 // hop limit
+		copy(packet[8:], srcIP.To16())
+		copy(packet[24:], dstIP.To16())
+		pseudo = append(append(append([]byte(nil), srcIP.To16()...), dstIP.To16()...), 0, 0, 0, 0, 0, 0, 0, 6)
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(segment)))
+	}
+	binary.BigEndian.PutUint16(segment[16:], foldIPChecksum(ipChecksum(ipChecksum(0, pseudo), segment)))
+	return append(packet, segment...)
+}
+
+// tcpEndpoint 返回地址的ip和端口, 不是tcp地址时为0.0.0.0:0
+func tcpEndpoint(addr net.Addr) (net.IP, uint16) {
+	if a, ok := addr.(*net.TCPAddr); ok && a.IP != nil {
+		return a.IP, uint16(a.Port)
+	}
+	return net.IPv4zero, 0
+}
+
+// ipChecksum 累加internet checksum
+func ipChecksum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+func foldIPChecksum(sum uint32) uint16 {
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
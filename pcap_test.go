@@ -0,0 +1,136 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// pcapRecords parses the pcap file, returns the link type and the packets.
+func pcapRecords(t *testing.T, b []byte) (uint32, [][]byte) {
+	t.Helper()
+	if len(b) < 24 || binary.LittleEndian.Uint32(b) != pcapMagic {
+		t.Fatalf("invalid pcap header [% x]", b)
+	}
+	linkType := binary.LittleEndian.Uint32(b[20:])
+	var packets [][]byte
+	for b = b[24:]; len(b) > 0; {
+		if len(b) < 16 {
+			t.Fatalf("truncated pcap record header [% x]", b)
+		}
+		n := int(binary.LittleEndian.Uint32(b[8:]))
+		if len(b) < 16+n || int(binary.LittleEndian.Uint32(b[12:])) != n {
+			t.Fatalf("invalid pcap record [% x]", b)
+		}
+		packets = append(packets, b[16:16+n])
+		b = b[16+n:]
+	}
+	return linkType, packets
+}
+
+func TestPcapWriter_tcp(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewPcapWriter(&buf, TransportTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewTCPServer().SetCapture(w)
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+
+	p := NewTCPClientProvider(addr, WithCapture(w))
+	client := NewClient(p)
+	if err = client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err = client.ReadCoils(0x01, 0, 8); err != nil {
+		t.Fatal(err)
+	}
+	// the server captures the response before writing it, all the frames are captured
+	w.Capture(CapturedFrame{Transport: TransportRTU, ADU: []byte{0x01}}) // ignored
+	if err = w.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	linkType, packets := pcapRecords(t, buf.Bytes())
+	if linkType != LinkTypeRaw {
+		t.Errorf("link type = %d, want %d", linkType, LinkTypeRaw)
+	}
+	if len(packets) != 4 {
+		t.Fatalf("packets = %d, want 4", len(packets))
+	}
+	for _, packet := range packets {
+		if len(packet) < 40 || packet[0] != 0x45 || packet[9] != 6 {
+			t.Fatalf("invalid ipv4 tcp packet [% x]", packet)
+		}
+		if got := foldIPChecksum(ipChecksum(0, packet[:20])); got != 0 {
+			t.Errorf("ip checksum of [% x] invalid", packet)
+		}
+		segment := packet[20:]
+		pseudo := append(append([]byte(nil), packet[12:20]...), 0, 6, 0, byte(len(segment)))
+		if got := foldIPChecksum(ipChecksum(ipChecksum(0, pseudo), segment)); got != 0 {
+			t.Errorf("tcp checksum of [% x] invalid", packet)
+		}
+		adu := segment[20:]
+		if len(adu) < tcpHeaderMbapSize+2 || adu[6] != 0x01 || adu[7] != FuncCodeReadCoils {
+			t.Errorf("payload = [% x], want read coils adu", adu)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w.Capture(CapturedFrame{Transport: TransportTCP, ADU: []byte{0x01}})
+	if _, packets = pcapRecords(t, buf.Bytes()); len(packets) != 4 {
+		t.Errorf("packets after Close = %d, want 4", len(packets))
+	}
+}
+
+func TestPcapWriter_serial(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewPcapWriter(&buf, TransportRTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := newPipePort()
+	defer port.Close()
+	go rtuSlave(port.remote)
+	p := NewRTUClientProvider(WithCapture(w))
+	p.port = port
+
+	if _, err = NewClient(p).ReadHoldingRegistersBytes(0x01, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	linkType, packets := pcapRecords(t, buf.Bytes())
+	if linkType != LinkTypeUser0 {
+		t.Errorf("link type = %d, want %d", linkType, LinkTypeUser0)
+	}
+	want := [][]byte{
+		rtuFrame(0x01, 0x03, 0x00, 0x00, 0x00, 0x01),
+		rtuFrame(0x01, 0x03, 0x02, 0x12, 0x34),
+	}
+	if len(packets) != len(want) {
+		t.Fatalf("packets = %d, want %d", len(packets), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(packets[i], want[i]) {
+			t.Errorf("packet %d = [% x], want [% x]", i, packets[i], want[i])
+		}
+	}
+}
+
+func TestPcapWriter_transportMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewPcapWriter(&buf, TransportRTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtu := rtuFrame(0x01, 0x03, 0x00, 0x00, 0x00, 0x01)
+	w.Capture(CapturedFrame{Time: time.Now(), Transport: TransportASCII, ADU: []byte(":010300000001FB\r\n")})
+	w.Capture(CapturedFrame{Time: time.Now(), Transport: TransportTCP, ADU: []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}})
+	w.Capture(CapturedFrame{Time: time.Now(), Transport: TransportRTU, ADU: rtu})
+	if _, packets := pcapRecords(t, buf.Bytes()); len(packets) != 1 || !bytes.Equal(packets[0], rtu) {
+		t.Errorf("packets = [% x], want only the rtu frame [% x]", packets, rtu)
+	}
+}
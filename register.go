@@ -3,7 +3,6 @@ package modbus
 // 本文件提供了寄存器的底层封装,并且是线程安全的,丰富的api满足基本需求
 
 import (
-	"bytes"
 	"encoding/binary"
 	"sync"
 )
@@ -20,6 +19,10 @@ type NodeRegister struct {
 	input                               []uint16
 	holdingAddrStart                    uint16
 	holding                             []uint16
+	coilsBlocks, discreteBlocks         []bitsBlock  // AddBlock增加的块
+	inputBlocks, holdingBlocks          []wordsBlock // AddBlock增加的块
+	subscribers                         subscribers  // 写入订阅
+	providers                           providers    // 读回调
 }
 
 // NewNodeRegister 创建一个modbus子节点寄存器列表
@@ -64,22 +67,22 @@ func (sf *NodeRegister) SetSlaveID(id byte) *NodeRegister {
 	return sf
 }
 
-// CoilsAddrParam 读coil起始地址与数量
+// CoilsAddrParam 读coil起始地址与数量, 仅首块, 不含AddBlock增加的块
 func (sf *NodeRegister) CoilsAddrParam() (start, quantity uint16) {
 	return sf.coilsAddrStart, sf.coilsQuantity
 }
 
-// DiscreteParam  读discrete起始地址与数量
+// DiscreteParam  读discrete起始地址与数量, 仅首块, 不含AddBlock增加的块
 func (sf *NodeRegister) DiscreteParam() (start, quantity uint16) {
 	return sf.discreteAddrStart, sf.discreteQuantity
 }
 
-// InputAddrParam  读input起始地址与数量
+// InputAddrParam  读input起始地址与数量, 仅首块, 不含AddBlock增加的块
 func (sf *NodeRegister) InputAddrParam() (start, quantity uint16) {
 	return sf.inputAddrStart, uint16(len(sf.input))
 }
 
-// HoldingAddrParam  读holding起始地址与数量
+// HoldingAddrParam  读holding起始地址与数量, 仅首块, 不含AddBlock增加的块
 func (sf *NodeRegister) HoldingAddrParam() (start, quantity uint16) {
 	return sf.holdingAddrStart, uint16(len(sf.holding))
 }
@@ -121,21 +124,33 @@ func setBits(buf []byte, start, nBits uint16, value byte) {
 
 // WriteCoils 写线圈
 func (sf *NodeRegister) WriteCoils(address, quality uint16, valBuf []byte) error {
+	return sf.writeBits(TableCoils, address, quality, valBuf, writeOrigin{})
+}
+
+// writeBits 写位表,写入提交后通知订阅
+func (sf *NodeRegister) writeBits(table Table, address, quality uint16, valBuf []byte, origin writeOrigin) error {
+	var buf [1]bitsSegment
 	sf.rw.Lock()
-	if len(valBuf)*8 >= int(quality) && (address >= sf.coilsAddrStart) &&
-		((address + quality) <= (sf.coilsAddrStart + sf.coilsQuantity)) {
-		start := address - sf.coilsAddrStart
-		nCoils := int16(quality)
-		for idx := 0; nCoils > 0; idx++ {
-			num := nCoils
-			if nCoils > 8 {
-				num = 8
-			}
-			setBits(sf.coils, start, uint16(num), valBuf[idx])
-			start += 8
-			nCoils -= 8
+	segments, ok := sf.bitsSpan(buf[:0], table, address, quality)
+	if len(valBuf)*8 >= int(quality) && ok {
+		var ev *WriteEvent
+		var old []byte
+
+		watched := sf.subscribers.watched(table, address, quality)
+		if watched {
+			old = readBitsSpan(segments, quality)
+		}
+		pos := uint16(0)
+		for _, seg := range segments {
+			copyBits(seg.buf, seg.start, valBuf, pos, seg.n)
+			pos += seg.n
+		}
+		if watched {
+			ev = &WriteEvent{sf.slaveID, table, address, bitsValue(old, 0, quality),
+				bitsValue(readBitsSpan(segments, quality), 0, quality), origin.funcCode, origin.session}
 		}
 		sf.rw.Unlock()
+		sf.subscribers.notify(ev)
 		return nil
 	}
 	sf.rw.Unlock()
@@ -151,27 +166,21 @@ func (sf *NodeRegister) WriteSingleCoil(address uint16, val bool) error {
 	return sf.WriteCoils(address, 1, []byte{newVal})
 }
 
-// ReadCoils 读线圈,返回值
-func (sf *NodeRegister) ReadCoils(address, quality uint16) ([]byte, error) {
+// readBits 读位表,返回值
+func (sf *NodeRegister) readBits(table Table, address, quality uint16) ([]byte, error) {
 	sf.rw.RLock()
-	if (address >= sf.coilsAddrStart) &&
-		((address + quality) <= (sf.coilsAddrStart + sf.coilsQuantity)) {
-		start := address - sf.coilsAddrStart
-		nCoils := int16(quality)
-		result := make([]byte, 0, (quality+7)/8)
-		for ; nCoils > 0; nCoils -= 8 {
-			num := nCoils
-			if nCoils > 8 {
-				num = 8
-			}
-			result = append(result, getBits(sf.coils, start, uint16(num)))
-			start += 8
-		}
-		sf.rw.RUnlock()
-		return result, nil
+	defer sf.rw.RUnlock()
+	var buf [1]bitsSegment
+	segments, ok := sf.bitsSpan(buf[:0], table, address, quality)
+	if !ok {
+		return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
 	}
-	sf.rw.RUnlock()
-	return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return readBitsSpan(segments, quality), nil
+}
+
+// ReadCoils 读线圈,返回值
+func (sf *NodeRegister) ReadCoils(address, quality uint16) ([]byte, error) {
+	return sf.readBits(TableCoils, address, quality)
 }
 
 // ReadSingleCoil 读单个线圈
@@ -185,25 +194,7 @@ func (sf *NodeRegister) ReadSingleCoil(address uint16) (bool, error) {
 
 // WriteDiscretes 写离散量
 func (sf *NodeRegister) WriteDiscretes(address, quality uint16, valBuf []byte) error {
-	sf.rw.Lock()
-	if len(valBuf)*8 >= int(quality) && (address >= sf.discreteAddrStart) &&
-		((address + quality) <= (sf.discreteAddrStart + sf.discreteQuantity)) {
-		start := address - sf.discreteAddrStart
-		nCoils := int16(quality)
-		for idx := 0; nCoils > 0; idx++ {
-			num := nCoils
-			if nCoils > 8 {
-				num = 8
-			}
-			setBits(sf.discrete, start, uint16(num), valBuf[idx])
-			start += 8
-			nCoils -= 8
-		}
-		sf.rw.Unlock()
-		return nil
-	}
-	sf.rw.Unlock()
-	return &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return sf.writeBits(TableDiscretes, address, quality, valBuf, writeOrigin{})
 }
 
 // WriteSingleDiscrete 写单个离散量
@@ -217,25 +208,11 @@ func (sf *NodeRegister) WriteSingleDiscrete(address uint16, val bool) error {
 
 // ReadDiscretes 读离散量
 func (sf *NodeRegister) ReadDiscretes(address, quality uint16) ([]byte, error) {
-	sf.rw.RLock()
-	if (address >= sf.discreteAddrStart) &&
-		((address + quality) <= (sf.discreteAddrStart + sf.discreteQuantity)) {
-		start := address - sf.discreteAddrStart
-		nCoils := int16(quality)
-		result := make([]byte, 0, (quality+7)/8)
-		for ; nCoils > 0; nCoils -= 8 {
-			num := nCoils
-			if nCoils > 8 {
-				num = 8
-			}
-			result = append(result, getBits(sf.discrete, start, uint16(num)))
-			start += 8
-		}
-		sf.rw.RUnlock()
-		return result, nil
+	result, err := sf.readBits(TableDiscretes, address, quality)
+	if err != nil {
+		return nil, err
 	}
-	sf.rw.RUnlock()
-	return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return sf.provideDiscretes(address, quality, result)
 }
 
 // ReadSingleDiscrete 读单个离散量
@@ -249,18 +226,43 @@ func (sf *NodeRegister) ReadSingleDiscrete(address uint16) (bool, error) {
 
 // WriteHoldingsBytes 写保持寄存器
 func (sf *NodeRegister) WriteHoldingsBytes(address, quality uint16, valBuf []byte) error {
+	return sf.writeWordsBytes(TableHoldings, address, quality, valBuf, writeOrigin{})
+}
+
+// writeWordsBytes 写寄存器表,写入提交后通知订阅
+func (sf *NodeRegister) writeWordsBytes(table Table, address, quality uint16, valBuf []byte, origin writeOrigin) error {
+	if len(valBuf) != int(quality)*2 {
+		return &ExceptionError{ExceptionCodeIllegalDataAddress}
+	}
+	value := make([]uint16, quality)
+	for i := range value {
+		value[i] = binary.BigEndian.Uint16(valBuf[i*2:])
+	}
+	return sf.writeWords(table, address, value, origin)
+}
+
+// writeWords 写寄存器表,写入提交后通知订阅
+func (sf *NodeRegister) writeWords(table Table, address uint16, valBuf []uint16, origin writeOrigin) error {
+	quality := uint16(len(valBuf))
+	var buf [1][]uint16
 	sf.rw.Lock()
-	if len(valBuf) == int(quality*2) &&
-		(address >= sf.holdingAddrStart) &&
-		((address + quality) <= (sf.holdingAddrStart + uint16(len(sf.holding)))) {
-		start := address - sf.holdingAddrStart
-		end := start + quality
-		buf := bytes.NewBuffer(valBuf)
-		err := binary.Read(buf, binary.BigEndian, sf.holding[start:end])
-		sf.rw.Unlock()
-		if err != nil {
-			return &ExceptionError{ExceptionCodeServerDeviceFailure}
+	if segments, ok := sf.wordsSpan(buf[:0], table, address, quality); ok {
+		var ev *WriteEvent
+
+		watched := sf.subscribers.watched(table, address, quality)
+		if watched {
+			ev = &WriteEvent{sf.slaveID, table, address, readWordsSpan(segments, quality),
+				nil, origin.funcCode, origin.session}
+		}
+		pos := 0
+		for _, seg := range segments {
+			pos += copy(seg, valBuf[pos:])
 		}
+		if watched {
+			ev.New = readWordsSpan(segments, quality)
+		}
+		sf.rw.Unlock()
+		sf.subscribers.notify(ev)
 		return nil
 	}
 	sf.rw.Unlock()
@@ -269,134 +271,94 @@ func (sf *NodeRegister) WriteHoldingsBytes(address, quality uint16, valBuf []byt
 
 // WriteHoldings 写保持寄存器
 func (sf *NodeRegister) WriteHoldings(address uint16, valBuf []uint16) error {
-	quality := uint16(len(valBuf))
-	sf.rw.Lock()
-	if (address >= sf.holdingAddrStart) &&
-		((address + quality) <= (sf.holdingAddrStart + uint16(len(sf.holding)))) {
-		start := address - sf.holdingAddrStart
-		end := start + quality
-		copy(sf.holding[start:end], valBuf)
-		sf.rw.Unlock()
-		return nil
+	return sf.writeWords(TableHoldings, address, valBuf, writeOrigin{})
+}
+
+// readWords 读寄存器表,仅返回寄存器值
+func (sf *NodeRegister) readWords(table Table, address, quality uint16) ([]uint16, error) {
+	sf.rw.RLock()
+	defer sf.rw.RUnlock()
+	var buf [1][]uint16
+	segments, ok := sf.wordsSpan(buf[:0], table, address, quality)
+	if !ok {
+		return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
 	}
-	sf.rw.Unlock()
-	return &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return readWordsSpan(segments, quality), nil
+}
+
+// wordsBytes 寄存器值转为大端字节
+func wordsBytes(value []uint16) []byte {
+	result := make([]byte, len(value)*2)
+	for i, v := range value {
+		binary.BigEndian.PutUint16(result[i*2:], v)
+	}
+	return result
 }
 
 // ReadHoldingsBytes 读保持寄存器,仅返回寄存器值
 func (sf *NodeRegister) ReadHoldingsBytes(address, quality uint16) ([]byte, error) {
-	sf.rw.RLock()
-	if (address >= sf.holdingAddrStart) &&
-		((address + quality) <= (sf.holdingAddrStart + uint16(len(sf.holding)))) {
-		start := address - sf.holdingAddrStart
-		end := start + quality
-		buf := new(bytes.Buffer)
-		err := binary.Write(buf, binary.BigEndian, sf.holding[start:end])
-		sf.rw.RUnlock()
-		if err != nil {
-			return nil, &ExceptionError{ExceptionCodeServerDeviceFailure}
-		}
-		return buf.Bytes(), nil
+	value, err := sf.ReadHoldings(address, quality)
+	if err != nil {
+		return nil, err
 	}
-	sf.rw.RUnlock()
-	return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return wordsBytes(value), nil
 }
 
 // ReadHoldings 读保持寄存器,仅返回寄存器值
 func (sf *NodeRegister) ReadHoldings(address, quality uint16) ([]uint16, error) {
-	sf.rw.RLock()
-	if (address >= sf.holdingAddrStart) &&
-		((address + quality) <= (sf.holdingAddrStart + uint16(len(sf.holding)))) {
-		start := address - sf.holdingAddrStart
-		end := start + quality
-		result := make([]uint16, quality)
-		copy(result, sf.holding[start:end])
-		sf.rw.RUnlock()
-		return result, nil
-	}
-	sf.rw.RUnlock()
-	return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return sf.readWords(TableHoldings, address, quality)
 }
 
 // WriteInputsBytes 写输入寄存器
 func (sf *NodeRegister) WriteInputsBytes(address, quality uint16, regBuf []byte) error {
-	sf.rw.Lock()
-	if len(regBuf) == int(quality*2) &&
-		(address >= sf.inputAddrStart) &&
-		((address + quality) <= (sf.inputAddrStart + uint16(len(sf.input)))) {
-		start := address - sf.inputAddrStart
-		end := start + quality
-		buf := bytes.NewBuffer(regBuf)
-		err := binary.Read(buf, binary.BigEndian, sf.input[start:end])
-		sf.rw.Unlock()
-		if err != nil {
-			return &ExceptionError{ExceptionCodeServerDeviceFailure}
-		}
-		return nil
-	}
-	sf.rw.Unlock()
-	return &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return sf.writeWordsBytes(TableInputs, address, quality, regBuf, writeOrigin{})
 }
 
 // WriteInputs 写输入寄存器
 func (sf *NodeRegister) WriteInputs(address uint16, valBuf []uint16) error {
-	quality := uint16(len(valBuf))
-	sf.rw.Lock()
-	if (address >= sf.inputAddrStart) &&
-		((address + quality) <= (sf.inputAddrStart + uint16(len(sf.input)))) {
-		start := address - sf.inputAddrStart
-		end := start + quality
-		copy(sf.input[start:end], valBuf)
-		sf.rw.Unlock()
-		return nil
-	}
-	sf.rw.Unlock()
-	return &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return sf.writeWords(TableInputs, address, valBuf, writeOrigin{})
 }
 
 // ReadInputsBytes 读输入寄存器
 func (sf *NodeRegister) ReadInputsBytes(address, quality uint16) ([]byte, error) {
-	sf.rw.RLock()
-	if (address >= sf.inputAddrStart) &&
-		((address + quality) <= (sf.inputAddrStart + uint16(len(sf.input)))) {
-		start := address - sf.inputAddrStart
-		end := start + quality
-		buf := new(bytes.Buffer)
-		err := binary.Write(buf, binary.BigEndian, sf.input[start:end])
-		sf.rw.RUnlock()
-		if err != nil {
-			return nil, &ExceptionError{ExceptionCodeServerDeviceFailure}
-		}
-		return buf.Bytes(), nil
+	value, err := sf.ReadInputs(address, quality)
+	if err != nil {
+		return nil, err
 	}
-	sf.rw.RUnlock()
-	return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return wordsBytes(value), nil
 }
 
 // ReadInputs 读输入寄存器
 func (sf *NodeRegister) ReadInputs(address, quality uint16) ([]uint16, error) {
-	sf.rw.RLock()
-	if (address >= sf.inputAddrStart) &&
-		((address + quality) <= (sf.inputAddrStart + uint16(len(sf.input)))) {
-		start := address - sf.inputAddrStart
-		end := start + quality
-		result := make([]uint16, quality)
-		copy(result, sf.input[start:end])
-		sf.rw.RUnlock()
-		return result, nil
+	result, err := sf.readWords(TableInputs, address, quality)
+	if err != nil {
+		return nil, err
 	}
-	sf.rw.RUnlock()
-	return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
+	return sf.provideInputs(address, result)
 }
 
 // MaskWriteHolding 屏蔽写保持寄存器 (val & andMask) | (orMask & ^andMask)
 func (sf *NodeRegister) MaskWriteHolding(address, andMask, orMask uint16) error {
+	return sf.maskWriteHolding(address, andMask, orMask, writeOrigin{})
+}
+
+// maskWriteHolding 屏蔽写保持寄存器,写入提交后通知订阅
+func (sf *NodeRegister) maskWriteHolding(address, andMask, orMask uint16, origin writeOrigin) error {
+	var buf [1][]uint16
 	sf.rw.Lock()
-	if (address >= sf.holdingAddrStart) &&
-		((address + 1) <= (sf.holdingAddrStart + uint16(len(sf.holding)))) {
-		sf.holding[address] &= andMask
-		sf.holding[address] |= orMask & ^andMask
+	if segments, ok := sf.wordsSpan(buf[:0], TableHoldings, address, 1); ok {
+		var ev *WriteEvent
+
+		reg := &segments[0][0]
+		old := *reg
+		*reg &= andMask
+		*reg |= orMask & ^andMask
+		if sf.subscribers.watched(TableHoldings, address, 1) {
+			ev = &WriteEvent{sf.slaveID, TableHoldings, address, []uint16{old},
+				[]uint16{*reg}, origin.funcCode, origin.session}
+		}
 		sf.rw.Unlock()
+		sf.subscribers.notify(ev)
 		return nil
 	}
 	sf.rw.Unlock()
@@ -0,0 +1,201 @@
+package modbus
+
+import (
+	"errors"
+)
+
+// bitsBlock 一段连续的位寄存器
+type bitsBlock struct {
+	addrStart uint16
+	quantity  uint16
+	bits      []byte
+}
+
+// wordsBlock 一段连续的16位寄存器
+type wordsBlock struct {
+	addrStart uint16
+	words     []uint16
+}
+
+// bitsSegment 位寄存器块中的一段, 从buf的第start位起n位
+type bitsSegment struct {
+	buf      []byte
+	start, n uint16
+}
+
+// AddBlock 在表table中增加一段[addrStart, addrStart+quantity)的寄存器块,
+// 一个表可以有多个不连续的块, 块之间的空洞应答非法数据地址异常, 相邻块可
+// 以一次读写. 与已有块重叠时返回错误.
+func (sf *NodeRegister) AddBlock(table Table, addrStart, quantity uint16) error {
+	if quantity == 0 || uint32(addrStart)+uint32(quantity) > 0x10000 {
+		return errors.New("invalid block range")
+	}
+	sf.rw.Lock()
+	defer sf.rw.Unlock()
+	switch table {
+	case TableCoils, TableDiscretes:
+		for _, b := range sf.bitsBlocks(table) {
+			if _, _, ok := overlap(b.addrStart, b.quantity, addrStart, quantity); ok {
+				return errors.New("block overlaps the existing one")
+			}
+		}
+		block := bitsBlock{addrStart, quantity, make([]byte, (int(quantity)+7)/8)}
+		if table == TableCoils {
+			sf.coilsBlocks = append(sf.coilsBlocks, block)
+		} else {
+			sf.discreteBlocks = append(sf.discreteBlocks, block)
+		}
+	case TableInputs, TableHoldings:
+		for _, b := range sf.wordsBlocks(table) {
+			if _, _, ok := overlap(b.addrStart, uint16(len(b.words)), addrStart, quantity); ok {
+				return errors.New("block overlaps the existing one")
+			}
+		}
+		block := wordsBlock{addrStart, make([]uint16, quantity)}
+		if table == TableHoldings {
+			sf.holdingBlocks = append(sf.holdingBlocks, block)
+		} else {
+			sf.inputBlocks = append(sf.inputBlocks, block)
+		}
+	default:
+		return errors.New("invalid table")
+	}
+	return nil
+}
+
+// bitsBlocks 位表的所有块, 首块为NewNodeRegister创建的块
+func (sf *NodeRegister) bitsBlocks(table Table) []bitsBlock {
+	if table == TableCoils {
+		return append([]bitsBlock{{sf.coilsAddrStart, sf.coilsQuantity, sf.coils}}, sf.coilsBlocks...)
+	}
+	return append([]bitsBlock{{sf.discreteAddrStart, sf.discreteQuantity, sf.discrete}}, sf.discreteBlocks...)
+}
+
+// wordsBlocks 寄存器表的所有块, 首块为NewNodeRegister创建的块
+func (sf *NodeRegister) wordsBlocks(table Table) []wordsBlock {
+	if table == TableHoldings {
+		return append([]wordsBlock{{sf.holdingAddrStart, sf.holding}}, sf.holdingBlocks...)
+	}
+	return append([]wordsBlock{{sf.inputAddrStart, sf.input}}, sf.inputBlocks...)
+}
+
+// bitsSpan 将覆盖[address, address+quantity)的各块中的片段追加到dst返回, 范围有空洞时返回false.
+// 范围在首块内时不遍历其它块, dst有容量时不分配内存.
+func (sf *NodeRegister) bitsSpan(dst []bitsSegment, table Table, address, quantity uint16) ([]bitsSegment, bool) {
+	first := bitsBlock{sf.coilsAddrStart, sf.coilsQuantity, sf.coils}
+	if table != TableCoils {
+		first = bitsBlock{sf.discreteAddrStart, sf.discreteQuantity, sf.discrete}
+	}
+	if quantity > 0 && address >= first.addrStart &&
+		uint32(address)+uint32(quantity) <= uint32(first.addrStart)+uint32(first.quantity) {
+		return append(dst, bitsSegment{first.bits, address - first.addrStart, quantity}), true
+	}
+
+	blocks := sf.bitsBlocks(table)
+	if quantity == 0 {
+		for _, b := range blocks {
+			if address >= b.addrStart && uint32(address) <= uint32(b.addrStart)+uint32(b.quantity) {
+				return dst, true
+			}
+		}
+		return nil, false
+	}
+
+	segments := dst
+	for cur, end := uint32(address), uint32(address)+uint32(quantity); cur < end; {
+		found := false
+		for _, b := range blocks {
+			start, stop := uint32(b.addrStart), uint32(b.addrStart)+uint32(b.quantity)
+			if cur >= start && cur < stop {
+				if stop > end {
+					stop = end
+				}
+				segments = append(segments, bitsSegment{b.bits, uint16(cur - start), uint16(stop - cur)})
+				cur, found = stop, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return segments, true
+}
+
+// wordsSpan 将覆盖[address, address+quantity)的各块中的片段追加到dst返回, 范围有空洞时返回false.
+// 范围在首块内时不遍历其它块, dst有容量时不分配内存.
+func (sf *NodeRegister) wordsSpan(dst [][]uint16, table Table, address, quantity uint16) ([][]uint16, bool) {
+	first := wordsBlock{sf.holdingAddrStart, sf.holding}
+	if table != TableHoldings {
+		first = wordsBlock{sf.inputAddrStart, sf.input}
+	}
+	if quantity > 0 && address >= first.addrStart &&
+		uint32(address)+uint32(quantity) <= uint32(first.addrStart)+uint32(len(first.words)) {
+		start := address - first.addrStart
+		return append(dst, first.words[start:start+quantity]), true
+	}
+
+	blocks := sf.wordsBlocks(table)
+	if quantity == 0 {
+		for _, b := range blocks {
+			if address >= b.addrStart && uint32(address) <= uint32(b.addrStart)+uint32(len(b.words)) {
+				return dst, true
+			}
+		}
+		return nil, false
+	}
+
+	segments := dst
+	for cur, end := uint32(address), uint32(address)+uint32(quantity); cur < end; {
+		found := false
+		for _, b := range blocks {
+			start, stop := uint32(b.addrStart), uint32(b.addrStart)+uint32(len(b.words))
+			if cur >= start && cur < stop {
+				if stop > end {
+					stop = end
+				}
+				segments = append(segments, b.words[cur-start:stop-start])
+				cur, found = stop, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return segments, true
+}
+
+// copyBits 复制src从srcStart起n位到dst从dstStart起的位置
+func copyBits(dst []byte, dstStart uint16, src []byte, srcStart, n uint16) {
+	for n > 0 {
+		num := n
+		if num > 8 {
+			num = 8
+		}
+		setBits(dst, dstStart, num, getBits(src, srcStart, num))
+		dstStart += num
+		srcStart += num
+		n -= num
+	}
+}
+
+// readBitsSpan 读取各片段的位, 按位打包, 低位在前
+func readBitsSpan(segments []bitsSegment, quantity uint16) []byte {
+	result := make([]byte, (int(quantity)+7)/8)
+	pos := uint16(0)
+	for _, seg := range segments {
+		copyBits(result, pos, seg.buf, seg.start, seg.n)
+		pos += seg.n
+	}
+	return result
+}
+
+// readWordsSpan 读取各片段的值
+func readWordsSpan(segments [][]uint16, quantity uint16) []uint16 {
+	result := make([]uint16, 0, quantity)
+	for _, seg := range segments {
+		result = append(result, seg...)
+	}
+	return result
+}
@@ -0,0 +1,105 @@
+package modbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNodeRegister_AddBlock(t *testing.T) {
+	node := NewNodeRegister(0x01, 0, 100, 0, 0, 0, 0, 0, 100)
+	tests := []struct {
+		name      string
+		table     Table
+		addrStart uint16
+		quantity  uint16
+		wantErr   bool
+	}{
+		{"holdings", TableHoldings, 1000, 100, false},
+		{"holdings adjacent", TableHoldings, 1100, 100, false},
+		{"holdings high", TableHoldings, 40000, 200, false},
+		{"holdings overlap", TableHoldings, 1050, 100, true},
+		{"holdings overlap first", TableHoldings, 99, 1, true},
+		{"coils", TableCoils, 1000, 10, false},
+		{"coils adjacent", TableCoils, 100, 12, false},
+		{"discretes", TableDiscretes, 0, 10, false},
+		{"inputs", TableInputs, 0, 10, false},
+		{"zero quantity", TableInputs, 100, 0, true},
+		{"out of range", TableInputs, 0xffff, 2, true},
+		{"invalid table", Table(9), 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := node.AddBlock(tt.table, tt.addrStart, tt.quantity); (err != nil) != tt.wantErr {
+				t.Errorf("AddBlock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// registers spanning adjacent blocks
+	value := make([]uint16, 120)
+	for i := range value {
+		value[i] = uint16(i)
+	}
+	if err := node.WriteHoldings(1050, value); err != nil {
+		t.Fatalf("WriteHoldings() error = %v, wantErr %v", err, nil)
+	}
+	got, err := node.ReadHoldings(1050, 120)
+	if err != nil {
+		t.Fatalf("ReadHoldings() error = %v, wantErr %v", err, nil)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("ReadHoldings() = %v, want %v", got, value)
+	}
+	// gap
+	for _, r := range [][2]uint16{{99, 2}, {1190, 20}, {500, 1}, {40199, 2}} {
+		if _, err = node.ReadHoldings(r[0], r[1]); err == nil {
+			t.Errorf("ReadHoldings(%d, %d) error = %v, wantErr %v", r[0], r[1], err, true)
+		}
+	}
+	if err = node.MaskWriteHolding(40199, 0x0000, 0x1234); err != nil {
+		t.Errorf("MaskWriteHolding() error = %v, wantErr %v", err, nil)
+	}
+
+	// coils spanning adjacent blocks
+	if err = node.WriteCoils(96, 10, []byte{0xff, 0x02}); err != nil {
+		t.Fatalf("WriteCoils() error = %v, wantErr %v", err, nil)
+	}
+	coils, err := node.ReadCoils(94, 14)
+	if err != nil {
+		t.Fatalf("ReadCoils() error = %v, wantErr %v", err, nil)
+	}
+	if want := []byte{0xfc, 0x0b}; !reflect.DeepEqual(coils, want) {
+		t.Errorf("ReadCoils() = %#v, want %#v", coils, want)
+	}
+	if _, err = node.ReadCoils(110, 3); err == nil {
+		t.Errorf("ReadCoils() error = %v, wantErr %v", err, true)
+	}
+
+	// handler answers exception 2 for gaps
+	_, err = funcReadHoldingRegisters(node, []byte{0x01, 0xf4, 0x00, 0x01})
+	if code := exceptionCode(err); code != ExceptionCodeIllegalDataAddress {
+		t.Errorf("funcReadHoldingRegisters() exception = %v, want %v", code, ExceptionCodeIllegalDataAddress)
+	}
+}
+
+func TestNodeRegister_singleBlockAllocs(t *testing.T) {
+	node := NewNodeRegister(1, 0, 16, 0, 16, 0, 16, 0, 16)
+	if err := node.AddBlock(TableHoldings, 100, 10); err != nil {
+		t.Fatal(err)
+	}
+	values := []uint16{1, 2, 3, 4}
+
+	if n := testing.AllocsPerRun(100, func() { _ = node.WriteHoldings(2, values) }); n != 0 {
+		t.Errorf("WriteHoldings() allocs = %v, want %v", n, 0)
+	}
+	if n := testing.AllocsPerRun(100, func() { _ = node.WriteCoils(2, 8, []byte{0xff}) }); n != 0 {
+		t.Errorf("WriteCoils() allocs = %v, want %v", n, 0)
+	}
+	// only the result
+	if n := testing.AllocsPerRun(100, func() { _, _ = node.ReadHoldings(2, 4) }); n != 1 {
+		t.Errorf("ReadHoldings() allocs = %v, want %v", n, 1)
+	}
+	if n := testing.AllocsPerRun(100, func() { _, _ = node.ReadCoils(2, 8) }); n != 1 {
+		t.Errorf("ReadCoils() allocs = %v, want %v", n, 1)
+	}
+}
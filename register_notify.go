@@ -0,0 +1,214 @@
+package modbus
+
+import (
+	"net"
+	"sync"
+)
+
+// Table 寄存器表
+type Table byte
+
+// register tables
+const (
+	TableCoils Table = iota
+	TableDiscretes
+	TableInputs
+	TableHoldings
+)
+
+// String implements fmt.Stringer.
+func (t Table) String() string {
+	switch t {
+	case TableCoils:
+		return "coils"
+	case TableDiscretes:
+		return "discretes"
+	case TableInputs:
+		return "inputs"
+	case TableHoldings:
+		return "holdings"
+	default:
+		return "unknown"
+	}
+}
+
+// Session 请求来源的会话
+type Session interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// WriteEvent 写入事件, 写入提交后通知
+type WriteEvent struct {
+	SlaveID byte
+	Table   Table
+	Address uint16   // 首地址, 已裁剪至订阅范围内
+	Old     []uint16 // 写入前的值, 位表为0或1
+	New     []uint16 // 写入后的值, 位表为0或1
+	// FuncCode 写入的功能码, 应用直接调用NodeRegister写入时为0
+	FuncCode byte
+	// Session 写入来源的会话, 应用直接调用NodeRegister写入时为nil
+	Session Session
+}
+
+// writeOrigin 写入来源
+type writeOrigin struct {
+	funcCode byte
+	session  Session
+}
+
+type subscriber struct {
+	table    Table
+	address  uint16
+	quantity uint16
+	fn       func(WriteEvent)
+	all      bool // all tables and addresses
+}
+
+// covers 返回写入范围与订阅范围的交集[lo, hi)
+func (sf *subscriber) covers(table Table, address, quantity uint16) (lo, hi uint32, ok bool) {
+	if sf.all {
+		return overlap(address, quantity, address, quantity)
+	}
+	if sf.table != table {
+		return 0, 0, false
+	}
+	return overlap(sf.address, sf.quantity, address, quantity)
+}
+
+// overlap 返回两个地址范围的交集[lo, hi)
+func overlap(address1, quantity1, address2, quantity2 uint16) (lo, hi uint32, ok bool) {
+	lo, hi = uint32(address1), uint32(address1)+uint32(quantity1)
+	if start := uint32(address2); lo < start {
+		lo = start
+	}
+	if end := uint32(address2) + uint32(quantity2); hi > end {
+		hi = end
+	}
+	return lo, hi, lo < hi
+}
+
+// subscribers 写入订阅列表, 零值可用
+type subscribers struct {
+	mu   sync.RWMutex
+	list []*subscriber
+}
+
+// watched 是否有订阅覆盖该写入范围
+func (sf *subscribers) watched(table Table, address, quantity uint16) bool {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	for _, s := range sf.list {
+		if _, _, ok := s.covers(table, address, quantity); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// notify 通知覆盖该写入范围的订阅, 事件裁剪到各自的订阅范围
+func (sf *subscribers) notify(ev *WriteEvent) {
+	if ev == nil {
+		return
+	}
+	sf.mu.RLock()
+	list := sf.list
+	sf.mu.RUnlock()
+
+	quantity := uint16(len(ev.New))
+	for _, s := range list {
+		lo, hi, ok := s.covers(ev.Table, ev.Address, quantity)
+		if !ok {
+			continue
+		}
+		e := *ev
+		e.Address = uint16(lo)
+		e.Old = ev.Old[lo-uint32(ev.Address) : hi-uint32(ev.Address)]
+		e.New = ev.New[lo-uint32(ev.Address) : hi-uint32(ev.Address)]
+		s.fn(e)
+	}
+}
+
+func (sf *subscribers) add(s *subscriber) func() {
+	sf.mu.Lock()
+	// copy on write, notify iterates without lock
+	list := make([]*subscriber, 0, len(sf.list)+1)
+	sf.list = append(append(list, sf.list...), s)
+	sf.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			sf.mu.Lock()
+			list := make([]*subscriber, 0, len(sf.list))
+			for _, v := range sf.list {
+				if v != s {
+					list = append(list, v)
+				}
+			}
+			sf.list = list
+			sf.mu.Unlock()
+		})
+	}
+}
+
+// Subscribe 订阅表table中[address, address+quantity)范围的写入, 写入提交后
+// 在写入者的goroutine中调用fn, fn不可阻塞太久, 返回取消订阅函数.
+func (sf *NodeRegister) Subscribe(table Table, address, quantity uint16, fn func(WriteEvent)) (cancel func()) {
+	return sf.subscribers.add(&subscriber{table: table, address: address, quantity: quantity, fn: fn})
+}
+
+// SubscribeChan 同Subscribe, 事件发送到ch, 发送会阻塞写入者直到ch可写,
+// 应使用带缓冲的ch并及时接收.
+func (sf *NodeRegister) SubscribeChan(table Table, address, quantity uint16, ch chan<- WriteEvent) (cancel func()) {
+	return sf.Subscribe(table, address, quantity, func(ev WriteEvent) { ch <- ev })
+}
+
+// subscribeAll 订阅所有表所有地址的写入
+func (sf *NodeRegister) subscribeAll(fn func(WriteEvent)) (cancel func()) {
+	return sf.subscribers.add(&subscriber{fn: fn, all: true})
+}
+
+// bitsValue 返回buf从start起quantity个位的值
+func bitsValue(buf []byte, start, quantity uint16) []uint16 {
+	result := make([]uint16, quantity)
+	for i := range result {
+		result[i] = uint16(getBits(buf, start+uint16(i), 1))
+	}
+	return result
+}
+
+// wordsValue 返回words的拷贝
+func wordsValue(words []uint16) []uint16 {
+	return append(make([]uint16, 0, len(words)), words...)
+}
+
+// originNode 携带写入来源的NodeRegister, 只传给内置的功能码回调,
+// 用户注册的回调收到NodeRegister本身.
+type originNode struct {
+	*NodeRegister
+	origin writeOrigin
+}
+
+// WriteCoils 写线圈
+func (sf *originNode) WriteCoils(address, quality uint16, valBuf []byte) error {
+	return sf.NodeRegister.writeBits(TableCoils, address, quality, valBuf, sf.origin)
+}
+
+// WriteHoldingsBytes 写保持寄存器
+func (sf *originNode) WriteHoldingsBytes(address, quality uint16, valBuf []byte) error {
+	return sf.NodeRegister.writeWordsBytes(TableHoldings, address, quality, valBuf, sf.origin)
+}
+
+// MaskWriteHolding 屏蔽写保持寄存器
+func (sf *originNode) MaskWriteHolding(address, andMask, orMask uint16) error {
+	return sf.NodeRegister.maskWriteHolding(address, andMask, orMask, sf.origin)
+}
+
+// withOrigin 为NodeRegister节点附加写入来源, 其它NodeStorage原样返回
+func withOrigin(storage NodeStorage, funcCode byte, session Session) NodeStorage {
+	if node, ok := storage.(*NodeRegister); ok {
+		return &originNode{node, writeOrigin{funcCode, session}}
+	}
+	return storage
+}
@@ -0,0 +1,70 @@
+package modbus
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+type testSession struct{}
+
+func (testSession) LocalAddr() net.Addr  { return &net.TCPAddr{Port: 502} }
+func (testSession) RemoteAddr() net.Addr { return &net.TCPAddr{Port: 50000} }
+
+func TestNodeRegister_Subscribe(t *testing.T) {
+	node := newNodeReg()
+	var events []WriteEvent
+	cancel := node.Subscribe(TableHoldings, 1, 2, func(ev WriteEvent) {
+		events = append(events, ev)
+	})
+
+	// write multiple registers 0~2, only 1~2 is subscribed
+	_, err := funcWriteMultiHoldingRegisters(withOrigin(node, FuncCodeWriteMultipleRegisters, testSession{}),
+		[]byte{0x00, 0x00, 0x00, 0x03, 0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03})
+	if err != nil {
+		t.Fatalf("funcWriteMultiHoldingRegisters() error = %v, wantErr %v", err, nil)
+	}
+	// out of subscribed range
+	if err = node.WriteHoldings(0, []uint16{0x0a}); err != nil {
+		t.Fatalf("WriteHoldings() error = %v, wantErr %v", err, nil)
+	}
+	// written by application
+	if err = node.MaskWriteHolding(2, 0x00f0, 0x0005); err != nil {
+		t.Fatalf("MaskWriteHolding() error = %v, wantErr %v", err, nil)
+	}
+	cancel()
+	if err = node.WriteHoldings(1, []uint16{0x0b}); err != nil {
+		t.Fatalf("WriteHoldings() error = %v, wantErr %v", err, nil)
+	}
+
+	want := []WriteEvent{
+		{0x01, TableHoldings, 1, []uint16{0x5678, 0x9012}, []uint16{0x0002, 0x0003},
+			FuncCodeWriteMultipleRegisters, testSession{}},
+		{0x01, TableHoldings, 2, []uint16{0x0003}, []uint16{0x0005}, 0, nil},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Subscribe() events = %+v, want %+v", events, want)
+	}
+}
+
+func TestNodeRegister_SubscribeChan(t *testing.T) {
+	node := newNodeReg()
+	ch := make(chan WriteEvent, 1)
+	defer node.SubscribeChan(TableCoils, 0, 16, ch)()
+
+	_, err := funcWriteSingleCoil(withOrigin(node, FuncCodeWriteSingleCoil, nil), []byte{0x00, 0x01, 0xff, 0x00})
+	if err != nil {
+		t.Fatalf("funcWriteSingleCoil() error = %v, wantErr %v", err, nil)
+	}
+	want := WriteEvent{0x01, TableCoils, 1, []uint16{0}, []uint16{1}, FuncCodeWriteSingleCoil, nil}
+	if got := <-ch; !reflect.DeepEqual(got, want) {
+		t.Errorf("SubscribeChan() event = %+v, want %+v", got, want)
+	}
+	// discretes are not subscribed
+	if err = node.WriteSingleDiscrete(1, true); err != nil {
+		t.Fatalf("WriteSingleDiscrete() error = %v, wantErr %v", err, nil)
+	}
+	if len(ch) != 0 {
+		t.Errorf("SubscribeChan() got unexpected event %+v", <-ch)
+	}
+}
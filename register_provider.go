@@ -0,0 +1,107 @@
+package modbus
+
+import (
+	"sync"
+)
+
+// InputsProvider 输入寄存器读回调, 返回[address, address+quantity)的值,
+// 返回ExceptionError时应答该异常码, 其它错误应答从站设备故障.
+type InputsProvider func(address, quantity uint16) ([]uint16, error)
+
+// DiscretesProvider 离散量读回调, 返回[address, address+quantity)的值,
+// 按位打包, 低位在前, 同ReadDiscretes. 错误处理同InputsProvider.
+type DiscretesProvider func(address, quantity uint16) ([]byte, error)
+
+type readProvider struct {
+	address   uint16
+	quantity  uint16
+	inputs    InputsProvider
+	discretes DiscretesProvider
+}
+
+// providers 读回调列表, 零值可用
+type providers struct {
+	mu        sync.RWMutex
+	inputs    []*readProvider
+	discretes []*readProvider
+}
+
+func (sf *providers) add(list *[]*readProvider, p *readProvider) func() {
+	sf.mu.Lock()
+	*list = append(append(make([]*readProvider, 0, len(*list)+1), *list...), p)
+	sf.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			sf.mu.Lock()
+			result := make([]*readProvider, 0, len(*list))
+			for _, v := range *list {
+				if v != p {
+					result = append(result, v)
+				}
+			}
+			*list = result
+			sf.mu.Unlock()
+		})
+	}
+}
+
+// AddInputsProvider 输入寄存器[address, address+quantity)的值在读取时由fn提供,
+// 覆盖寄存器中存储的值, 读取范围仍受限于输入寄存器的地址范围, 返回移除函数.
+func (sf *NodeRegister) AddInputsProvider(address, quantity uint16, fn InputsProvider) (remove func()) {
+	return sf.providers.add(&sf.providers.inputs, &readProvider{address: address, quantity: quantity, inputs: fn})
+}
+
+// AddDiscretesProvider 离散量[address, address+quantity)的值在读取时由fn提供,
+// 覆盖寄存器中存储的值, 读取范围仍受限于离散量的地址范围, 返回移除函数.
+func (sf *NodeRegister) AddDiscretesProvider(address, quantity uint16, fn DiscretesProvider) (remove func()) {
+	return sf.providers.add(&sf.providers.discretes, &readProvider{address: address, quantity: quantity, discretes: fn})
+}
+
+// provideInputs 用读回调的值覆盖从address起读取的输入寄存器值result
+func (sf *NodeRegister) provideInputs(address uint16, result []uint16) ([]uint16, error) {
+	sf.providers.mu.RLock()
+	list := sf.providers.inputs
+	sf.providers.mu.RUnlock()
+
+	for _, p := range list {
+		lo, hi, ok := overlap(p.address, p.quantity, address, uint16(len(result)))
+		if !ok {
+			continue
+		}
+		value, err := p.inputs(uint16(lo), uint16(hi-lo))
+		if err != nil {
+			return nil, err
+		}
+		if len(value) != int(hi-lo) {
+			return nil, &ExceptionError{ExceptionCodeServerDeviceFailure}
+		}
+		copy(result[lo-uint32(address):], value)
+	}
+	return result, nil
+}
+
+// provideDiscretes 用读回调的值覆盖从address起读取的quantity个离散量值result
+func (sf *NodeRegister) provideDiscretes(address, quantity uint16, result []byte) ([]byte, error) {
+	sf.providers.mu.RLock()
+	list := sf.providers.discretes
+	sf.providers.mu.RUnlock()
+
+	for _, p := range list {
+		lo, hi, ok := overlap(p.address, p.quantity, address, quantity)
+		if !ok {
+			continue
+		}
+		n := uint16(hi - lo)
+		value, err := p.discretes(uint16(lo), n)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) != (int(n)+7)/8 {
+			return nil, &ExceptionError{ExceptionCodeServerDeviceFailure}
+		}
+		copyBits(result, uint16(lo)-address, value, 0, n)
+	}
+	return result, nil
+}
@@ -0,0 +1,71 @@
+package modbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNodeRegister_AddInputsProvider(t *testing.T) {
+	node := newNodeReg()
+	fail := false
+	remove := node.AddInputsProvider(1, 10, func(address, quantity uint16) ([]uint16, error) {
+		if fail {
+			return nil, &ExceptionError{ExceptionCodeServerDeviceBusy}
+		}
+		value := make([]uint16, quantity)
+		for i := range value {
+			value[i] = 0xa000 + address + uint16(i)
+		}
+		return value, nil
+	})
+
+	got, err := funcReadInputRegisters(node, []byte{0x00, 0x00, 0x00, 0x03})
+	if err != nil {
+		t.Fatalf("funcReadInputRegisters() error = %v, wantErr %v", err, nil)
+	}
+	if want := []byte{0x06, 0x90, 0x12, 0xa0, 0x01, 0xa0, 0x02}; !reflect.DeepEqual(got, want) {
+		t.Errorf("funcReadInputRegisters() = %#v, want %#v", got, want)
+	}
+
+	fail = true
+	_, err = funcReadInputRegisters(node, []byte{0x00, 0x01, 0x00, 0x01})
+	if code := exceptionCode(err); code != ExceptionCodeServerDeviceBusy {
+		t.Errorf("funcReadInputRegisters() exception = %v, want %v", code, ExceptionCodeServerDeviceBusy)
+	}
+	// not covered by provider
+	if _, err = funcReadInputRegisters(node, []byte{0x00, 0x00, 0x00, 0x01}); err != nil {
+		t.Errorf("funcReadInputRegisters() error = %v, wantErr %v", err, nil)
+	}
+
+	remove()
+	value, err := node.ReadInputs(0, 3)
+	if err != nil {
+		t.Fatalf("ReadInputs() error = %v, wantErr %v", err, nil)
+	}
+	if want := []uint16{0x9012, 0x1234, 0x5678}; !reflect.DeepEqual(value, want) {
+		t.Errorf("ReadInputs() = %#v, want %#v", value, want)
+	}
+}
+
+func TestNodeRegister_AddDiscretesProvider(t *testing.T) {
+	node := newNodeReg()
+	defer node.AddDiscretesProvider(2, 4, func(address, quantity uint16) ([]byte, error) {
+		return []byte{0x0f}, nil // all on
+	})()
+	defer node.AddDiscretesProvider(12, 1, func(address, quantity uint16) ([]byte, error) {
+		return nil, nil // wrong size
+	})()
+
+	// discrete: 0xaa 0x55, bit 2~5 on
+	got, err := funcReadDiscreteInputs(node, []byte{0x00, 0x00, 0x00, 0x08})
+	if err != nil {
+		t.Fatalf("funcReadDiscreteInputs() error = %v, wantErr %v", err, nil)
+	}
+	if want := []byte{0x01, 0xbe}; !reflect.DeepEqual(got, want) {
+		t.Errorf("funcReadDiscreteInputs() = %#v, want %#v", got, want)
+	}
+	_, err = funcReadDiscreteInputs(node, []byte{0x00, 0x08, 0x00, 0x08})
+	if code := exceptionCode(err); code != ExceptionCodeServerDeviceFailure {
+		t.Errorf("funcReadDiscreteInputs() exception = %v, want %v", code, ExceptionCodeServerDeviceFailure)
+	}
+}
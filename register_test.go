@@ -699,6 +699,23 @@ func TestNodeRegister_MaskWriteHolding(t *testing.T) {
 	}
 }
 
+func TestNodeRegister_MaskWriteHolding_startAddress(t *testing.T) {
+	nodeReg := NewNodeRegister(1, 0, 0, 0, 0, 0, 0, 100, 3)
+	if err := nodeReg.WriteHoldings(100, []uint16{0x0000, 0x0012, 0x0000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeReg.MaskWriteHolding(101, 0xf2, 0x25); err != nil {
+		t.Fatalf("NodeRegister.MaskWriteHolding() error = %v, wantErr %v", err, false)
+	}
+	got, err := nodeReg.ReadHoldings(100, 3)
+	if want := []uint16{0x0000, 0x0017, 0x0000}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("NodeRegister.MaskWriteHolding() got = %#v, want %#v", got, want)
+	}
+	if err = nodeReg.MaskWriteHolding(99, 0xf2, 0x25); err == nil {
+		t.Errorf("NodeRegister.MaskWriteHolding() below start error = %v, wantErr %v", err, true)
+	}
+}
+
 func Benchmark_getBits(b *testing.B) {
 	val := []byte{0x00, 0x02, 0x03, 0x04, 0x05}
 	for i := 0; i < b.N; i++ {
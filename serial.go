@@ -8,8 +8,13 @@ import (
 	"github.com/goburrow/serial"
 )
 
-// SerialDefaultTimeout Serial Default timeout
-const SerialDefaultTimeout = 1 * time.Second
+// serial default value
+const (
+	// SerialDefaultTimeout Serial Default timeout
+	SerialDefaultTimeout = 1 * time.Second
+	// SerialDefaultTurnaroundDelay Serial Default turnaround delay after broadcast
+	SerialDefaultTurnaroundDelay = 100 * time.Millisecond
+)
 
 // serialPort has configuration and I/O controller.
 type serialPort struct {
@@ -17,6 +22,11 @@ type serialPort struct {
 	serial.Config
 	mu   sync.Mutex
 	port io.ReadWriteCloser
+	// nil means serial.Open
+	open PortOpenFunc
+	// the bus keeps quiet after broadcast for slaves processing it
+	turnaroundDelay time.Duration
+	quietUntil      time.Time
 }
 
 // Connect try to connect the remote server
@@ -30,7 +40,13 @@ func (sf *serialPort) Connect() (err error) {
 // Caller must hold the mutex before calling this method.
 func (sf *serialPort) connect() error {
 	if sf.port == nil {
-		port, err := serial.Open(&sf.Config)
+		var port io.ReadWriteCloser
+		var err error
+		if sf.open != nil {
+			port, err = sf.open(&sf.Config)
+		} else {
+			port, err = serial.Open(&sf.Config)
+		}
 		if err != nil {
 			return err
 		}
@@ -54,6 +70,43 @@ func (sf *serialPort) setSerialConfig(config serial.Config) {
 
 func (sf *serialPort) setTCPTimeout(time.Duration) {}
 
+func (sf *serialPort) setFrameSilence(time.Duration) {}
+
+func (sf *serialPort) setDialer(DialFunc) {}
+
+func (sf *serialPort) setPortOpener(open PortOpenFunc) {
+	sf.open = open
+}
+
+func (sf *serialPort) setTurnaroundDelay(t time.Duration) {
+	sf.turnaroundDelay = t
+}
+
+// waitTurnaround wait the turnaround delay of the last broadcast elapsed.
+// Caller must hold the mutex before calling this method.
+func (sf *serialPort) waitTurnaround() {
+	if d := time.Until(sf.quietUntil); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// broadcastSent start the turnaround delay after broadcast.
+// Caller must hold the mutex before calling this method.
+func (sf *serialPort) broadcastSent() {
+	sf.quietUntil = time.Now().Add(sf.turnaroundDelay)
+}
+
+// verifyBroadcast check the function can be broadcast, only the write functions can.
+func verifyBroadcast(funcCode byte) error {
+	switch funcCode {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteMultipleCoils,
+		FuncCodeWriteSingleRegister, FuncCodeWriteMultipleRegisters,
+		FuncCodeMaskWriteRegister:
+		return nil
+	}
+	return &BroadcastError{funcCode}
+}
+
 func (sf *serialPort) close() (err error) {
 	if sf.port != nil {
 		err = sf.port.Close()
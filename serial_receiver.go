@@ -0,0 +1,141 @@
+package modbus
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// frameReceiver splits the serial byte stream into frames by the inter-frame
+// silence, a frame is completed when the line has been idle for t3.5.
+// It reads the port in a background goroutine, which exits when the port is
+// closed or a read error occurs. The goroutine buffers up to 64 reads, the
+// bytes read while the buffer is full are dropped and counted, the frame they
+// belong to is corrupted, see takeDropped.
+type frameReceiver struct {
+	dropped uint64 // bytes dropped since the buffer is full, keep it first for 64-bit alignment
+	port    io.Reader
+	silence time.Duration
+	data    chan []byte
+	done    chan struct{}
+	err     error // valid after done closed
+}
+
+// newFrameReceiver start a frame receiver on port with t3.5 silence.
+func newFrameReceiver(port io.Reader, silence time.Duration) *frameReceiver {
+	sf := &frameReceiver{
+		port:    port,
+		silence: silence,
+		data:    make(chan []byte, 64),
+		done:    make(chan struct{}),
+	}
+	go sf.readLoop()
+	return sf
+}
+
+func (sf *frameReceiver) readLoop() {
+	defer close(sf.done)
+	for {
+		buf := make([]byte, rtuAduMaxSize)
+		n, err := sf.port.Read(buf)
+		if n > 0 {
+			select {
+			case sf.data <- buf[:n]:
+			default: // nobody receives for a long time, drop it
+				atomic.AddUint64(&sf.dropped, uint64(n))
+			}
+		}
+		if err != nil && err != serial.ErrTimeout {
+			sf.err = err
+			return
+		}
+	}
+}
+
+// stopped reports whether the read loop has exited.
+func (sf *frameReceiver) stopped() bool {
+	select {
+	case <-sf.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// takeDropped returns the bytes dropped since the last call.
+func (sf *frameReceiver) takeDropped() uint64 {
+	return atomic.SwapUint64(&sf.dropped, 0)
+}
+
+// flush discards all the pending data.
+func (sf *frameReceiver) flush() {
+	for {
+		select {
+		case <-sf.data:
+		default:
+			return
+		}
+	}
+}
+
+// receive wait the first byte of a frame up to timeout, then collect bytes
+// until the silence of t3.5, frames longer than maxSize are returned as soon
+// as maxSize reached, timeout <= 0 means wait forever.
+func (sf *frameReceiver) receive(timeout time.Duration, maxSize int) ([]byte, error) {
+	var frame []byte
+	var expired, idle <-chan time.Time
+	var silence *time.Timer
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case b := <-sf.data:
+			frame = append(frame, b...)
+			if len(frame) >= maxSize {
+				return frame, nil
+			}
+			if silence == nil {
+				// the frame has begun, wait for silence only
+				silence = time.NewTimer(sf.silence)
+				defer silence.Stop()
+				idle, expired = silence.C, nil
+			} else {
+				if !silence.Stop() {
+					select {
+					case <-silence.C:
+					default:
+					}
+				}
+				silence.Reset(sf.silence)
+			}
+		case <-idle:
+			return frame, nil
+		case <-expired:
+			return nil, &TimeoutError{serial.ErrTimeout}
+		case <-sf.done:
+			// take the bytes read before exit
+			for len(sf.data) > 0 {
+				frame = append(frame, <-sf.data...)
+			}
+			if len(frame) > 0 {
+				return frame, nil
+			}
+			return nil, sf.err
+		}
+	}
+}
+
+// calculateSilence calculates t3.5 inter-frame silence for baud rate.
+// See MODBUS over Serial Line - Specification and Implementation Guide (page 13).
+func calculateSilence(baudRate int) time.Duration {
+	if baudRate <= 0 || baudRate > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return time.Duration(35000000/baudRate) * time.Microsecond
+}
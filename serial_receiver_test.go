@@ -0,0 +1,108 @@
+package modbus
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pipePort is a in memory serial port, what written to it can be read from
+// remote, and what remote written can be read from it.
+type pipePort struct {
+	io.Reader
+	io.Writer
+	remote *pipePort
+}
+
+func newPipePort() *pipePort {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	local := &pipePort{Reader: r1, Writer: w2}
+	local.remote = &pipePort{Reader: r2, Writer: w1, remote: local}
+	return local
+}
+
+func (sf *pipePort) Close() error {
+	sf.Reader.(*io.PipeReader).Close()
+	sf.Writer.(*io.PipeWriter).Close()
+	return nil
+}
+
+func Test_frameReceiver_receive(t *testing.T) {
+	port := newPipePort()
+	defer port.Close()
+	recv := newFrameReceiver(port, 20*time.Millisecond)
+
+	go func() {
+		_, _ = port.remote.Write([]byte{0x01, 0x03, 0x02})
+		time.Sleep(5 * time.Millisecond) // less than t3.5, same frame
+		_, _ = port.remote.Write([]byte{0x12, 0x34, 0xb5, 0x33})
+		time.Sleep(50 * time.Millisecond) // silence, next frame
+		_, _ = port.remote.Write([]byte{0x01, 0x83, 0x02, 0xc0, 0xf1})
+	}()
+
+	got, err := recv.receive(time.Second, rtuAduMaxSize)
+	if err != nil {
+		t.Fatalf("receive() error = %v, wantErr %v", err, nil)
+	}
+	if want := []byte{0x01, 0x03, 0x02, 0x12, 0x34, 0xb5, 0x33}; !reflect.DeepEqual(got, want) {
+		t.Errorf("receive() = % x, want % x", got, want)
+	}
+	got, err = recv.receive(time.Second, rtuAduMaxSize)
+	if err != nil {
+		t.Fatalf("receive() error = %v, wantErr %v", err, nil)
+	}
+	if want := []byte{0x01, 0x83, 0x02, 0xc0, 0xf1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("receive() = % x, want % x", got, want)
+	}
+	if _, err = recv.receive(30*time.Millisecond, rtuAduMaxSize); !errors.Is(err, ErrTimeout) {
+		t.Errorf("receive() error = %v, wantErr %v", err, ErrTimeout)
+	}
+}
+
+func TestRTUClientProvider_silenceFraming(t *testing.T) {
+	port := newPipePort()
+	defer port.Close()
+
+	p := NewRTUClientProvider(WithFrameSilence(10 * time.Millisecond))
+	p.port = port
+	go func() {
+		req := make([]byte, 8)
+		if _, err := io.ReadFull(port.remote, req); err != nil {
+			return
+		}
+		// report slave id, which response length can not be predicted
+		_, _ = port.remote.Write([]byte{0x01, 0x11, 0x03})
+		time.Sleep(2 * time.Millisecond)
+		_, _ = port.remote.Write([]byte{0x0a, 0xff, 0x01, 0x5d, 0xbf})
+	}()
+
+	got, err := p.SendPdu(0x01, []byte{0x11, 0x00, 0x00, 0x00, 0x00})
+	if err != nil {
+		t.Fatalf("SendPdu() error = %v, wantErr %v", err, nil)
+	}
+	if want := []byte{0x11, 0x03, 0x0a, 0xff, 0x01}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SendPdu() = % x, want % x", got, want)
+	}
+}
+
+func Test_frameReceiver_dropped(t *testing.T) {
+	port := newPipePort()
+	defer port.Close()
+	recv := newFrameReceiver(port, 20*time.Millisecond)
+
+	for i := 0; i < cap(recv.data)+2; i++ { // nobody receives, the last two are dropped
+		if _, err := port.remote.Write([]byte{0x01, 0x02}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got := recv.takeDropped(); got != 4 {
+		t.Errorf("takeDropped() = %d, want %d", got, 4)
+	}
+	if got := recv.takeDropped(); got != 0 {
+		t.Errorf("takeDropped() again = %d, want %d", got, 0)
+	}
+}
@@ -0,0 +1,89 @@
+package modbus
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStats snapshot of the server metrics.
+type ServerStats struct {
+	Requests       map[byte]uint64 // request count by function code
+	UnitRequests   map[byte]uint64 // request count by unit(slave) id
+	Exceptions     map[byte]uint64 // exception response count by exception code
+	BytesIn        uint64          // request frame bytes received
+	BytesOut       uint64          // response frame bytes sent
+	ActiveSessions int64
+	HandlerTime    time.Duration // total time spent in function handlers
+	MaxHandlerTime time.Duration // the max time spent in a function handler
+}
+
+// serverMetrics all fields are accessed atomically.
+type serverMetrics struct {
+	bytesIn        uint64
+	bytesOut       uint64
+	activeSessions int64
+	handlerTime    int64
+	maxHandlerTime int64
+	requests       [256]uint64
+	units          [256]uint64
+	exceptions     [256]uint64
+}
+
+func (sf *serverMetrics) request(slaveID, funcCode byte, size int) {
+	atomic.AddUint64(&sf.requests[funcCode], 1)
+	atomic.AddUint64(&sf.units[slaveID], 1)
+	atomic.AddUint64(&sf.bytesIn, uint64(size))
+}
+
+func (sf *serverMetrics) exception(code byte) {
+	atomic.AddUint64(&sf.exceptions[code], 1)
+}
+
+func (sf *serverMetrics) sent(size int) {
+	atomic.AddUint64(&sf.bytesOut, uint64(size))
+}
+
+func (sf *serverMetrics) handled(d time.Duration) {
+	atomic.AddInt64(&sf.handlerTime, int64(d))
+	for {
+		max := atomic.LoadInt64(&sf.maxHandlerTime)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&sf.maxHandlerTime, max, int64(d)) {
+			return
+		}
+	}
+}
+
+func (sf *serverMetrics) sessionOpened() { atomic.AddInt64(&sf.activeSessions, 1) }
+func (sf *serverMetrics) sessionClosed() { atomic.AddInt64(&sf.activeSessions, -1) }
+
+func countersOf(counters *[256]uint64) map[byte]uint64 {
+	m := make(map[byte]uint64)
+	for i := range counters {
+		if v := atomic.LoadUint64(&counters[i]); v > 0 {
+			m[byte(i)] = v
+		}
+	}
+	return m
+}
+
+// Stats returns the snapshot of the server metrics.
+func (sf *serverCommon) Stats() ServerStats {
+	m := sf.metrics
+	return ServerStats{
+		Requests:       countersOf(&m.requests),
+		UnitRequests:   countersOf(&m.units),
+		Exceptions:     countersOf(&m.exceptions),
+		BytesIn:        atomic.LoadUint64(&m.bytesIn),
+		BytesOut:       atomic.LoadUint64(&m.bytesOut),
+		ActiveSessions: atomic.LoadInt64(&m.activeSessions),
+		HandlerTime:    time.Duration(atomic.LoadInt64(&m.handlerTime)),
+		MaxHandlerTime: time.Duration(atomic.LoadInt64(&m.maxHandlerTime)),
+	}
+}
+
+// PublishExpvar publish the server metrics through expvar with name, such as
+// "modbus_server", it panics if the name is already registered like expvar.Publish.
+func (sf *serverCommon) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return sf.Stats() }))
+}
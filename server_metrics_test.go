@@ -0,0 +1,72 @@
+package modbus
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTCPServer_Stats(t *testing.T) {
+	srv := NewTCPServer()
+	name := "modbus_test_server_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	srv.PublishExpvar(name)
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !readCoilsRaw(conn) {
+		t.Fatalf("request not served")
+	}
+	// illegal function
+	if _, err = conn.Write([]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x01, 0x41}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(conn, make([]byte, 9)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := srv.Stats()
+	if want := map[byte]uint64{0x01: 1, 0x41: 1}; !reflect.DeepEqual(stats.Requests, want) {
+		t.Errorf("Stats().Requests = %v, want %v", stats.Requests, want)
+	}
+	if want := map[byte]uint64{0x01: 2}; !reflect.DeepEqual(stats.UnitRequests, want) {
+		t.Errorf("Stats().UnitRequests = %v, want %v", stats.UnitRequests, want)
+	}
+	if want := map[byte]uint64{ExceptionCodeIllegalFunction: 1}; !reflect.DeepEqual(stats.Exceptions, want) {
+		t.Errorf("Stats().Exceptions = %v, want %v", stats.Exceptions, want)
+	}
+	if stats.BytesIn != 20 || stats.BytesOut != 19 {
+		t.Errorf("Stats() bytes in/out = %v/%v, want %v/%v", stats.BytesIn, stats.BytesOut, 20, 19)
+	}
+	if stats.ActiveSessions != 1 {
+		t.Errorf("Stats().ActiveSessions = %v, want %v", stats.ActiveSessions, 1)
+	}
+	if stats.HandlerTime <= 0 || stats.MaxHandlerTime > stats.HandlerTime {
+		t.Errorf("Stats() handler time = %v, max %v", stats.HandlerTime, stats.MaxHandlerTime)
+	}
+
+	var published ServerStats
+	if err = json.Unmarshal([]byte(expvar.Get(name).String()), &published); err != nil {
+		t.Fatalf("expvar unmarshal error = %v", err)
+	}
+	if !reflect.DeepEqual(published.Requests, stats.Requests) {
+		t.Errorf("expvar Requests = %v, want %v", published.Requests, stats.Requests)
+	}
+
+	conn.Close()
+	for i := 0; i < 100 && srv.Stats().ActiveSessions != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := srv.Stats().ActiveSessions; n != 0 {
+		t.Errorf("Stats().ActiveSessions = %v, want %v", n, 0)
+	}
+}
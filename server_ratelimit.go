@@ -0,0 +1,91 @@
+package modbus
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimitPolicy what to do with the request over the rate limit.
+type RateLimitPolicy byte
+
+// rate limit policies
+const (
+	// RateLimitBusy reply server device busy exception.
+	RateLimitBusy RateLimitPolicy = iota
+	// RateLimitDelay delay the request until it is allowed.
+	RateLimitDelay
+)
+
+// rateLimiter token bucket, tokens refill at rate per second up to burst.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter new token bucket with full tokens, nil if rate <= 0 which
+// means no limit.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (sf *rateLimiter) refill(now time.Time) {
+	if !sf.last.IsZero() {
+		sf.tokens += now.Sub(sf.last).Seconds() * sf.rate
+		if sf.tokens > sf.burst {
+			sf.tokens = sf.burst
+		}
+	}
+	sf.last = now
+}
+
+// allow takes a token if there is one.
+func (sf *rateLimiter) allow(now time.Time) bool {
+	if sf == nil {
+		return true
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.refill(now)
+	if sf.tokens < 1 {
+		return false
+	}
+	sf.tokens--
+	return true
+}
+
+// refund gives back a token taken by allow.
+func (sf *rateLimiter) refund() {
+	if sf == nil {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.tokens++; sf.tokens > sf.burst {
+		sf.tokens = sf.burst
+	}
+}
+
+// reserve takes a token in advance, returns how long to wait until it is
+// available.
+func (sf *rateLimiter) reserve(now time.Time) time.Duration {
+	if sf == nil {
+		return 0
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.refill(now)
+	sf.tokens--
+	if sf.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-sf.tokens / sf.rate * float64(time.Second))
+}
@@ -0,0 +1,48 @@
+package modbus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_rateLimiter(t *testing.T) {
+	if newRateLimiter(0, 10) != nil {
+		t.Errorf("newRateLimiter() with rate 0 should be nil")
+	}
+	var unlimited *rateLimiter
+	if !unlimited.allow(time.Now()) || unlimited.reserve(time.Now()) != 0 {
+		t.Errorf("nil rateLimiter should not limit")
+	}
+
+	now := time.Now()
+	limiter := newRateLimiter(10, 2)
+	if !limiter.allow(now) || !limiter.allow(now) {
+		t.Errorf("allow() within burst = false, want true")
+	}
+	if limiter.allow(now) {
+		t.Errorf("allow() over burst = true, want false")
+	}
+	if !limiter.allow(now.Add(100 * time.Millisecond)) {
+		t.Errorf("allow() after refill = false, want true")
+	}
+	if wait := limiter.reserve(now.Add(100 * time.Millisecond)); wait != 100*time.Millisecond {
+		t.Errorf("reserve() = %v, want %v", wait, 100*time.Millisecond)
+	}
+}
+
+func TestServerSession_acquire_globalLimit(t *testing.T) {
+	sess := &ServerSession{
+		limiter: newRateLimiter(0.001, 2),
+		global:  newRateLimiter(0.001, 1),
+	}
+	for i, want := range []bool{false, true, true, true} {
+		if busy, err := sess.acquire(context.Background()); err != nil || busy != want {
+			t.Errorf("request %d acquire() = %v, %v, want %v", i, busy, err, want)
+		}
+	}
+	// the requests rejected by the global limit do not drain the session budget
+	if sess.limiter.tokens < 1 {
+		t.Errorf("session tokens = %v, want at least 1", sess.limiter.tokens)
+	}
+}
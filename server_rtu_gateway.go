@@ -0,0 +1,198 @@
+package modbus
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// RTUGateway 串口RTU从站网关, 在串口上作为配置的从站地址的RTU从站, 将请求pdu
+// 通过TCPClientProvider转发到Modbus TCP设备, 应答以RTU帧返回.
+// 请求帧以t3.5静默分帧, 应答在请求帧结束t3.5之后整帧发送.
+// 没有路由的从站地址和校验失败的帧不应答, 广播依次转发到所有下游设备,
+// 不等待下游设备应答, 不应答.
+type RTUGateway struct {
+	serial.Config
+	mu     sync.Mutex
+	routes []rtuGatewayRoute
+	t35    time.Duration // 0 means derive it from the baud rate
+	port   io.ReadWriteCloser
+	closed bool
+	logger
+}
+
+// noReplySender 不等待应答发送请求的下游设备, 如TCPClientProvider
+type noReplySender interface {
+	sendNoReply(slaveID byte, pduRequest []byte) error
+}
+
+// rtuGatewayRoute 从站地址[first, last]路由到下游设备
+type rtuGatewayRoute struct {
+	first, last byte
+	provider    ClientProvider
+}
+
+// NewRTUGateway new serial rtu slave gateway on the serial config,
+// 使用AddRoute添加下游设备.
+func NewRTUGateway(config serial.Config) *RTUGateway {
+	return &RTUGateway{
+		Config: config,
+		logger: newLogger("modbusRTUGateway => "),
+	}
+}
+
+// SetFrameSilence 设置t3.5帧间静默时间, t35 <= 0 由波特率计算, 默认由波特率计算.
+func (sf *RTUGateway) SetFrameSilence(t35 time.Duration) *RTUGateway {
+	sf.mu.Lock()
+	sf.t35 = t35
+	sf.mu.Unlock()
+	return sf
+}
+
+// AddRoute 作为从站应答从站地址[first, last]的请求, 并转发到provider,
+// 地址范围不可与已有路由重叠.
+func (sf *RTUGateway) AddRoute(first, last byte, provider ClientProvider) error {
+	if first == AddressBroadCast || first > last {
+		return errors.New("modbus: gateway route unit id range is invalid")
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, r := range sf.routes {
+		if first <= r.last && r.first <= last {
+			return errors.New("modbus: gateway route overlaps an existing one")
+		}
+	}
+	sf.routes = append(sf.routes, rtuGatewayRoute{first, last, provider})
+	return nil
+}
+
+// ListenAndServe open the serial port and serve, see Serve.
+func (sf *RTUGateway) ListenAndServe() error {
+	port, err := serial.Open(&sf.Config)
+	if err != nil {
+		return err
+	}
+	return sf.Serve(port)
+}
+
+// Serve serve the rtu master on the port until Close or the port failed,
+// always returns a non-nil error, ErrServerClosed after Close.
+func (sf *RTUGateway) Serve(port io.ReadWriteCloser) error {
+	sf.mu.Lock()
+	if sf.closed {
+		sf.mu.Unlock()
+		port.Close()
+		return ErrServerClosed
+	}
+	sf.port = port
+	t35 := sf.t35
+	if t35 <= 0 {
+		t35 = calculateSilence(sf.BaudRate)
+	}
+	sf.mu.Unlock()
+
+	sf.Debugf("gateway started, serial port %s", sf.Address)
+	recv := newFrameReceiver(port, t35)
+	for {
+		adu, err := recv.receive(0, rtuAduMaxSize)
+		if n := recv.takeDropped(); n > 0 {
+			sf.Debugf("receive buffer full, dropped %d bytes", n)
+		}
+		if err != nil {
+			sf.mu.Lock()
+			closed := sf.closed
+			sf.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			port.Close()
+			return err
+		}
+		if response := sf.handle(adu); response != nil {
+			sf.Debugf("TX Raw[% x]", response)
+			if _, err = port.Write(response); err != nil {
+				port.Close()
+				return err
+			}
+		}
+	}
+}
+
+// Close close the serial port, Serve returns ErrServerClosed,
+// the downstream providers are not closed.
+func (sf *RTUGateway) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.closed = true
+	if sf.port == nil {
+		return nil
+	}
+	return sf.port.Close()
+}
+
+// handle 转发请求帧, 返回应答帧, 返回nil时不应答
+func (sf *RTUGateway) handle(adu []byte) []byte {
+	sf.Debugf("RX Raw[% x]", adu)
+	slaveID, pdu, err := decodeRTUFrame(adu)
+	if err != nil {
+		sf.Debugf("discard invalid frame, %v", err)
+		return nil
+	}
+
+	if slaveID == AddressBroadCast { // no response, do not wait for the devices
+		for _, provider := range sf.providers() {
+			if p, ok := provider.(noReplySender); ok {
+				err = p.sendNoReply(slaveID, pdu)
+			} else { // serial providers do not wait for the broadcast response
+				_, err = provider.SendPdu(slaveID, pdu)
+			}
+			if err != nil {
+				sf.Debugf("broadcast failed, %v", err)
+			}
+		}
+		return nil
+	}
+	provider := sf.route(slaveID)
+	if provider == nil { // other slave on the bus
+		return nil
+	}
+	rspPdu, err := provider.SendPdu(slaveID, pdu)
+	if err != nil {
+		sf.Debugf("slave(%d) request failed, %v", slaveID, err)
+		rspPdu = []byte{pdu[0] | 0x80, gatewayException(err).ExceptionCode}
+	}
+	response := make([]byte, 0, len(rspPdu)+3)
+	response = append(append(response, slaveID), rspPdu...)
+	checksum := CRC16(response)
+	return append(response, byte(checksum), byte(checksum>>8))
+}
+
+// route 查找从站地址的下游设备
+func (sf *RTUGateway) route(slaveID byte) ClientProvider {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, r := range sf.routes {
+		if slaveID >= r.first && slaveID <= r.last {
+			return r.provider
+		}
+	}
+	return nil
+}
+
+// providers 返回所有下游设备, 去重
+func (sf *RTUGateway) providers() []ClientProvider {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	list := make([]ClientProvider, 0, len(sf.routes))
+	seen := make(map[ClientProvider]bool)
+	for _, r := range sf.routes {
+		if !seen[r.provider] {
+			seen[r.provider] = true
+			list = append(list, r.provider)
+		}
+	}
+	return list
+}
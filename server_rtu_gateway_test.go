@@ -0,0 +1,128 @@
+package modbus
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// rtuFrame appends crc to adu.
+func rtuFrame(adu ...byte) []byte {
+	checksum := CRC16(adu)
+	return append(adu, byte(checksum), byte(checksum>>8))
+}
+
+func TestRTUGateway(t *testing.T) {
+	srv := NewTCPServer()
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+	// nothing listen on it
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen.Close()
+
+	port := newPipePort()
+	gw := NewRTUGateway(serial.Config{BaudRate: 19200}).SetFrameSilence(5 * time.Millisecond)
+	if err = gw.AddRoute(1, 1, NewTCPClientProvider(addr)); err != nil {
+		t.Fatal(err)
+	}
+	if err = gw.AddRoute(9, 9, NewTCPClientProvider(listen.Addr().String())); err != nil {
+		t.Fatal(err)
+	}
+	if err = gw.AddRoute(0, 3, NewTCPClientProvider(addr)); err == nil {
+		t.Errorf("AddRoute() broadcast error = %v, wantErr %v", err, true)
+	}
+	done := make(chan error, 1)
+	go func() { done <- gw.Serve(port) }()
+
+	tests := []struct {
+		name    string
+		request []byte
+		want    []byte
+	}{
+		{"relay response", rtuFrame(0x01, 0x03, 0x00, 0x00, 0x00, 0x01), rtuFrame(0x01, 0x03, 0x02, 0x00, 0x00)},
+		{"relay exception", rtuFrame(0x01, 0x03, 0x00, 0x20, 0x00, 0x01), rtuFrame(0x01, 0x83, 0x02)},
+		{"target failed to respond", rtuFrame(0x09, 0x03, 0x00, 0x00, 0x00, 0x01), rtuFrame(0x09, 0x83, 0x0b)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// other slave on the bus and corrupted frame are not answered
+			_, _ = port.remote.Write(rtuFrame(0x05, 0x03, 0x00, 0x00, 0x00, 0x01))
+			time.Sleep(20 * time.Millisecond)
+			_, _ = port.remote.Write([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00})
+			time.Sleep(20 * time.Millisecond)
+
+			_, _ = port.remote.Write(tt.request)
+			got := make([]byte, len(tt.want))
+			if _, err := io.ReadFull(port.remote, got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("response = % x, want % x", got, tt.want)
+			}
+		})
+	}
+
+	gw.Close()
+	select {
+	case err = <-done:
+		if err != ErrServerClosed {
+			t.Errorf("Serve() error = %v, want %v", err, ErrServerClosed)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Serve() not return after Close")
+	}
+}
+
+func TestRTUGateway_broadcast(t *testing.T) {
+	// a tcp device which never answers the broadcast, and records the unit id in order
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listen.Close()
+	units := make(chan byte, 4)
+	go func() {
+		conn, err := listen.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			request := make([]byte, 12)
+			if _, err = io.ReadFull(conn, request); err != nil {
+				return
+			}
+			units <- request[6]
+			if request[6] != AddressBroadCast {
+				_, _ = conn.Write(append(request[:4:4], 0x00, 0x05, request[6], 0x03, 0x02, 0x12, 0x34))
+			}
+		}
+	}()
+
+	gw := NewRTUGateway(serial.Config{BaudRate: 19200})
+	if err = gw.AddRoute(1, 2, NewTCPClientProvider(listen.Addr().String(), WithTCPTimeout(2*time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	if got := gw.handle(rtuFrame(0x00, 0x06, 0x00, 0x01, 0x12, 0x34)); got != nil {
+		t.Errorf("handle() broadcast = % x, want no response", got)
+	}
+	// the device is not locked by the broadcast waiting for the response until timeout
+	start := time.Now()
+	if got, want := gw.handle(rtuFrame(0x01, 0x03, 0x00, 0x01, 0x00, 0x01)), rtuFrame(0x01, 0x03, 0x02, 0x12, 0x34); !reflect.DeepEqual(got, want) {
+		t.Errorf("handle() = % x, want % x", got, want)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("handle() elapsed %v, want it is not blocked by the broadcast", elapsed)
+	}
+	// the broadcast reaches the device before the next request
+	if first, second := <-units, <-units; first != AddressBroadCast || second != 0x01 {
+		t.Errorf("device got unit %d then %d, want %d then %d", first, second, AddressBroadCast, 0x01)
+	}
+}
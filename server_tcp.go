@@ -2,8 +2,10 @@ package modbus
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,14 +15,35 @@ const (
 	TCPDefaultWriteTimeout = 1 * time.Second
 )
 
+// ErrServerClosed returned by Serve and ListenAndServe after Close or Shutdown.
+var ErrServerClosed = errors.New("modbus: server closed")
+
 // TCPServer modbus tcp server
 type TCPServer struct {
 	mu           sync.Mutex
-	listen       net.Listener
+	listeners    map[net.Listener]struct{}
 	wg           sync.WaitGroup
+	ctx          context.Context // canceled when close or shutdown
 	cancel       context.CancelFunc
+	closed       bool // after close or shutdown, can not serve again
 	readTimeout  time.Duration
 	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	frameTimeout time.Duration
+
+	maxConns      int
+	evictIdle     bool
+	maxConnsPerIP int
+	allowList     []*net.IPNet
+	denyList      []*net.IPNet
+	sessions      map[*ServerSession]string // session -> remote ip
+	connsPerIP    map[string]int
+
+	rateLimiter  *rateLimiter // global
+	sessionRate  float64
+	sessionBurst int
+	ratePolicy   RateLimitPolicy
+	capturer
 	*serverCommon
 	logger
 }
@@ -47,43 +70,133 @@ func (sf *TCPServer) SetWriteTimeout(t time.Duration) *TCPServer {
 	return sf
 }
 
-// Close close the server until all server close then return
+// SetCapture capture every received request and sent response of the new
+// sessions to the sink, such as PcapWriter, nil means disable.
+func (sf *TCPServer) SetCapture(sink CaptureSink) *TCPServer {
+	sf.setCapture(sink)
+	return sf
+}
+
+// Close close the server immediately, close all listeners and connections,
+// then wait until all session exit.
 func (sf *TCPServer) Close() error {
 	sf.mu.Lock()
-	if sf.listen != nil {
-		sf.listen.Close()
-		sf.cancel()
-		sf.listen = nil
+	sf.stop()
+	for sess := range sf.sessions {
+		sess.conn.Close()
 	}
 	sf.mu.Unlock()
 	sf.wg.Wait()
 	return nil
 }
 
+// shutdownPollInterval how often Shutdown checks the idle sessions.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown gracefully shutdown the server, close all listeners, close the idle
+// connections, and wait for the in-flight requests to finish, connections
+// still active when ctx done are force closed and ctx error returned.
+func (sf *TCPServer) Shutdown(ctx context.Context) error {
+	sf.mu.Lock()
+	sf.stop()
+	sf.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sf.wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		sf.mu.Lock()
+		for sess := range sf.sessions {
+			if atomic.CompareAndSwapInt32(&sess.state, sessionIdle, sessionClosed) {
+				sess.conn.Close()
+			}
+		}
+		sf.mu.Unlock()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			sf.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// stop close all listeners and cancel the sessions, the server can not
+// serve again, must be called with mu held.
+func (sf *TCPServer) stop() {
+	sf.closed = true
+	// cancel first, so that Serve knows the listener is closed by us
+	if sf.cancel != nil {
+		sf.cancel()
+	}
+	for l := range sf.listeners {
+		l.Close()
+	}
+	sf.listeners = nil
+}
+
+// context returns the context of the running server, must be called with mu held.
+func (sf *TCPServer) context() context.Context {
+	if sf.ctx == nil {
+		sf.ctx, sf.cancel = context.WithCancel(context.Background())
+	}
+	return sf.ctx
+}
+
 const minTempDelay = 5 * time.Millisecond
 
-// ListenAndServe listen and server
+// ListenAndServe listen on tcp address and serve, see Serve.
 func (sf *TCPServer) ListenAndServe(addr string) error {
 	listen, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithCancel(context.Background())
+	return sf.Serve(listen)
+}
+
+// Serve accept connections on the listener, such as tcp, unix socket or
+// systemd activated listener, and serve each of them in a new goroutine.
+// It can be called with multiple listeners, always returns a non-nil error,
+// ErrServerClosed after Close or Shutdown.
+func (sf *TCPServer) Serve(listen net.Listener) error {
 	sf.mu.Lock()
-	sf.listen = listen
-	sf.cancel = cancel
+	if sf.closed {
+		sf.mu.Unlock()
+		listen.Close()
+		return ErrServerClosed
+	}
+	ctx := sf.context()
+	if sf.listeners == nil {
+		sf.listeners = make(map[net.Listener]struct{})
+	}
+	sf.listeners[listen] = struct{}{}
 	sf.mu.Unlock()
 
-	sf.Debugf("server started,and listen address: %s", addr)
+	sf.Debugf("server started,and listen address: %s", listen.Addr())
 	defer func() {
-		sf.Close()
-		sf.Debugf("server stopped")
+		sf.mu.Lock()
+		delete(sf.listeners, listen)
+		sf.mu.Unlock()
+		listen.Close()
+		sf.Debugf("server stopped listen address: %s", listen.Addr())
 	}()
 	var tempDelay = minTempDelay // how long to sleep on accept failure
 
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
+			select {
+			case <-ctx.Done():
+				return ErrServerClosed
+			default:
+			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				tempDelay <<= 1
 				if max := 1 * time.Second; tempDelay > max {
@@ -95,17 +208,63 @@ func (sf *TCPServer) ListenAndServe(addr string) error {
 			return err
 		}
 		tempDelay = minTempDelay
-		sf.wg.Add(1)
-		go func() {
-			sess := &ServerSession{
-				conn,
-				sf.readTimeout,
-				sf.writeTimeout,
-				sf.serverCommon,
-				sf.logger,
-			}
-			sess.running(ctx)
-			sf.wg.Done()
-		}()
+		sess, err := sf.newSession(ctx, conn)
+		if err != nil {
+			sf.Debugf("client(%v) rejected, %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		go sf.serveSession(ctx, sess)
+	}
+}
+
+// ServeConn serve a single session over any stream connection, such as
+// serial over tcp or in-memory pipe, it blocks until the session exit and
+// closes the connection. Connection limits and ip filters are applied.
+// It returns ErrServerClosed after Close or Shutdown.
+func (sf *TCPServer) ServeConn(conn net.Conn) error {
+	sf.mu.Lock()
+	if sf.closed {
+		sf.mu.Unlock()
+		conn.Close()
+		return ErrServerClosed
 	}
+	ctx := sf.context()
+	sf.mu.Unlock()
+
+	sess, err := sf.newSession(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	sf.serveSession(ctx, sess)
+	return nil
+}
+
+// newSession new session on the conn, admit and track it.
+func (sf *TCPServer) newSession(ctx context.Context, conn net.Conn) (*ServerSession, error) {
+	sess := &ServerSession{
+		conn:         conn,
+		readTimeout:  sf.readTimeout,
+		writeTimeout: sf.writeTimeout,
+		idleTimeout:  sf.idleTimeout,
+		frameTimeout: sf.frameTimeout,
+		limiter:      newRateLimiter(sf.sessionRate, sf.sessionBurst),
+		global:       sf.rateLimiter,
+		ratePolicy:   sf.ratePolicy,
+		capturer:     sf.capturer,
+		serverCommon: sf.serverCommon,
+		logger:       sf.logger,
+	}
+	sess.touch()
+	if err := sf.admit(ctx, sess); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (sf *TCPServer) serveSession(ctx context.Context, sess *ServerSession) {
+	sess.running(ctx)
+	sf.release(sess)
+	sf.wg.Done()
 }
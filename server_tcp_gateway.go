@@ -0,0 +1,274 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TCPGatewayDefaultTimeout 网关默认请求超时, 含排队等待总线的时间
+const TCPGatewayDefaultTimeout = 3 * time.Second
+
+// gatewayQueueSize 每条下游总线的请求队列长度
+const gatewayQueueSize = 64
+
+// TCPGateway modbus tcp 网关, 按从站地址将请求pdu转发到下游串口(RTU或ASCII)
+// 设备, 应答或异常以原MBAP头返回, 不使用本地节点.
+// 每个下游ClientProvider一个请求队列, 串行访问总线, 不同串口之间并发.
+type TCPGateway struct {
+	*TCPServer
+	mu      sync.RWMutex
+	routes  []*gatewayRoute
+	queues  map[ClientProvider]*gatewayQueue
+	timeout time.Duration
+	cache   *readCache // nil means disabled
+}
+
+// gatewayRoute 从站地址[first, last]路由到下游总线
+type gatewayRoute struct {
+	first, last byte
+	queue       *gatewayQueue
+	rule        *GatewayRule // nil means no translation
+}
+
+// gatewayQueue 下游总线请求队列
+type gatewayQueue struct {
+	provider ClientProvider
+	requests chan *gatewayRequest
+	done     chan struct{}
+	once     sync.Once
+	*logger
+}
+
+type gatewayRequest struct {
+	slaveID  byte
+	pdu      []byte
+	deadline time.Time          // zero means no limit
+	result   chan gatewayResult // buffered, requester may have gone
+}
+
+type gatewayResult struct {
+	pdu []byte
+	err error
+}
+
+// NewTCPGateway new modbus tcp gateway, 使用AddRoute添加下游总线.
+func NewTCPGateway() *TCPGateway {
+	sf := &TCPGateway{
+		TCPServer: NewTCPServer(),
+		queues:    make(map[ClientProvider]*gatewayQueue),
+		timeout:   TCPGatewayDefaultTimeout,
+	}
+	sf.TCPServer.logger = newLogger("modbusTCPGateway => ")
+	sf.forward = sf.relay
+	return sf
+}
+
+// SetRequestTimeout 设置请求超时, 含排队等待总线的时间, 超时应答异常0x0B,
+// t <= 0 不限制, 只受下游provider自身的超时限制, 默认TCPGatewayDefaultTimeout.
+func (sf *TCPGateway) SetRequestTimeout(t time.Duration) *TCPGateway {
+	sf.mu.Lock()
+	sf.timeout = t
+	sf.mu.Unlock()
+	return sf
+}
+
+// SetReadCache 设置读缓存, 相同的读请求(下游设备, 从站地址, 功能码, 地址, 数量,
+// 均为路由规则转换后的值)在ttl内从缓存应答, 并发的相同读请求合并为一次下游请求,
+// 写请求使重叠的缓存失效, ttl <= 0 关闭读缓存, 默认关闭.
+func (sf *TCPGateway) SetReadCache(ttl time.Duration) *TCPGateway {
+	sf.mu.Lock()
+	if ttl > 0 {
+		sf.cache = newReadCache(ttl)
+	} else {
+		sf.cache = nil
+	}
+	sf.mu.Unlock()
+	return sf
+}
+
+// AddRoute 将从站地址[first, last]的请求转发到provider, 同一provider的多个
+// 路由共用一个请求队列, 地址范围不可与已有路由重叠.
+func (sf *TCPGateway) AddRoute(first, last byte, provider ClientProvider) error {
+	if first > last {
+		return errors.New("modbus: gateway route first unit id must not be greater than last")
+	}
+	return sf.addRoute(&gatewayRoute{first: first, last: last}, provider)
+}
+
+// addRoute 添加路由, 为provider创建请求队列
+func (sf *TCPGateway) addRoute(route *gatewayRoute, provider ClientProvider) error {
+	first, last := route.first, route.last
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, r := range sf.routes {
+		if first <= r.last && r.first <= last {
+			return errors.New("modbus: gateway route overlaps an existing one")
+		}
+	}
+	queue, ok := sf.queues[provider]
+	if !ok {
+		queue = &gatewayQueue{
+			provider: provider,
+			requests: make(chan *gatewayRequest, gatewayQueueSize),
+			done:     make(chan struct{}),
+			logger:   &sf.TCPServer.logger,
+		}
+		sf.queues[provider] = queue
+		go queue.run()
+	}
+	route.queue = queue
+	sf.routes = append(sf.routes, route)
+	return nil
+}
+
+// Close close the gateway immediately, and stop all request queues,
+// the downstream providers are not closed.
+func (sf *TCPGateway) Close() error {
+	err := sf.TCPServer.Close()
+	sf.stopQueues()
+	return err
+}
+
+// Shutdown gracefully shuts down the gateway like TCPServer.Shutdown,
+// then stop all request queues.
+func (sf *TCPGateway) Shutdown(ctx context.Context) error {
+	err := sf.TCPServer.Shutdown(ctx)
+	sf.stopQueues()
+	return err
+}
+
+func (sf *TCPGateway) stopQueues() {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	for _, queue := range sf.queues {
+		queue.stop()
+	}
+}
+
+// route 查找从站地址的下游总线
+func (sf *TCPGateway) route(slaveID byte) (*gatewayRoute, time.Duration, *readCache) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	for _, r := range sf.routes {
+		if slaveID >= r.first && slaveID <= r.last {
+			return r, sf.timeout, sf.cache
+		}
+	}
+	return nil, sf.timeout, sf.cache
+}
+
+// relay 转发请求pdu到下游总线, 等待应答pdu, 没有路由应答异常0x0A, 广播不应答,
+// 超时或下游设备无有效应答时应答异常0x0B.
+func (sf *TCPGateway) relay(slaveID byte, pdu []byte) ([]byte, error) {
+	route, timeout, cache := sf.route(slaveID)
+	if route == nil {
+		if slaveID == AddressBroadCast { // never answered
+			return nil, nil
+		}
+		return nil, &ExceptionError{ExceptionCodeGatewayPathUnavailable}
+	}
+	return route.send(slaveID, pdu, timeout, cache)
+}
+
+// send 按路由规则转换请求并转发, 应答转换回请求的地址
+func (sf *gatewayRoute) send(slaveID byte, pdu []byte, timeout time.Duration, cache *readCache) ([]byte, error) {
+	if sf.rule == nil {
+		return sf.queue.cached(cache, slaveID, pdu, timeout)
+	}
+	request, err := sf.rule.translate(pdu)
+	if err != nil {
+		return nil, err
+	}
+	response, err := sf.queue.cached(cache, sf.rule.TargetUnitID, request, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return restoreAddress(pdu, response), nil
+}
+
+// cached 经读缓存转发转换后的请求, 缓存以下游设备的地址为键, 不同路由规则
+// 映射到同一设备同一地址的读写共用缓存. cache为nil时直接转发.
+func (sf *gatewayQueue) cached(cache *readCache, slaveID byte, pdu []byte, timeout time.Duration) ([]byte, error) {
+	if cache == nil {
+		return sf.send(slaveID, pdu, timeout)
+	}
+	if key, ok := cacheableRead(sf.provider, slaveID, pdu); ok {
+		return cache.do(key, func() ([]byte, error) {
+			return sf.send(slaveID, pdu, timeout)
+		})
+	}
+	rspPdu, err := sf.send(slaveID, pdu, timeout)
+	if funcCode, address, quantity, ok := writeRange(pdu); ok {
+		cache.invalidate(sf.provider, slaveID, funcCode, address, quantity)
+	}
+	return rspPdu, err
+}
+
+// send 请求入队, 等待应答pdu
+func (sf *gatewayQueue) send(slaveID byte, pdu []byte, timeout time.Duration) ([]byte, error) {
+	req := &gatewayRequest{
+		slaveID: slaveID,
+		// the session reuses its buffer, the queue may still hold it after timeout
+		pdu:    append([]byte(nil), pdu...),
+		result: make(chan gatewayResult, 1),
+	}
+	var expired <-chan time.Time // nil means no limit
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+		req.deadline = time.Now().Add(timeout)
+	}
+	select {
+	case sf.requests <- req:
+	case <-sf.done:
+		return nil, &ExceptionError{ExceptionCodeGatewayPathUnavailable}
+	case <-expired:
+		return nil, &ExceptionError{ExceptionCodeGatewayTargetDeviceFailedToRespond}
+	}
+	select {
+	case rsp := <-req.result:
+		return rsp.pdu, rsp.err
+	case <-sf.done:
+		return nil, &ExceptionError{ExceptionCodeGatewayPathUnavailable}
+	case <-expired:
+		return nil, &ExceptionError{ExceptionCodeGatewayTargetDeviceFailedToRespond}
+	}
+}
+
+// run 串行处理队列中的请求, 直到stop
+func (sf *gatewayQueue) run() {
+	for {
+		select {
+		case <-sf.done:
+			return
+		case req := <-sf.requests:
+			if !req.deadline.IsZero() && time.Now().After(req.deadline) { // requester has replied timeout
+				continue
+			}
+			pdu, err := sf.provider.SendPdu(req.slaveID, req.pdu)
+			if err != nil {
+				sf.Debugf("slave(%d) request failed, %v", req.slaveID, err)
+				req.result <- gatewayResult{nil, gatewayException(err)}
+				continue
+			}
+			// the provider may reuse its buffer
+			req.result <- gatewayResult{append([]byte(nil), pdu...), nil}
+		}
+	}
+}
+
+func (sf *gatewayQueue) stop() {
+	sf.once.Do(func() { close(sf.done) })
+}
+
+// gatewayException 下游设备的异常原样返回, 其它错误返回异常0x0B
+func gatewayException(err error) *ExceptionError {
+	var e *ExceptionError
+	if errors.As(err, &e) {
+		return e
+	}
+	return &ExceptionError{ExceptionCodeGatewayTargetDeviceFailedToRespond}
+}
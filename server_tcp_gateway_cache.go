@@ -0,0 +1,160 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// readCacheSweepSize 缓存条目达到该数量时清理过期条目
+const readCacheSweepSize = 1024
+
+// readKey 下游设备的读请求
+type readKey struct {
+	provider ClientProvider
+	slaveID  byte
+	funcCode byte
+	address  uint16
+	quantity uint16
+}
+
+// cacheEntry 读请求的应答, done关闭前为正在进行的下游请求
+type cacheEntry struct {
+	done    chan struct{}
+	pdu     []byte
+	err     error
+	expires time.Time
+	invalid bool // invalidated by a write while in flight, guarded by readCache.mu
+}
+
+// readCache 网关读缓存, 相同的读请求在ttl内从缓存应答, 并发的相同读请求
+// 合并为一次下游请求, 写请求完成后使重叠的缓存失效.
+type readCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[readKey]*cacheEntry
+}
+
+func newReadCache(ttl time.Duration) *readCache {
+	return &readCache{ttl: ttl, entries: make(map[readKey]*cacheEntry)}
+}
+
+// do 从缓存应答读请求, 没有时调用send, 并发的相同请求等待同一个send,
+// 等待的请求被写请求失效时重新请求, 不使用写之前的应答.
+func (sf *readCache) do(key readKey, send func() ([]byte, error)) ([]byte, error) {
+	for {
+		now := time.Now()
+		sf.mu.Lock()
+		if e, ok := sf.entries[key]; ok {
+			select {
+			case <-e.done:
+				if now.Before(e.expires) {
+					sf.mu.Unlock()
+					return e.pdu, nil
+				}
+			default: // in flight, wait for it
+				sf.mu.Unlock()
+				<-e.done
+				sf.mu.Lock()
+				invalid := e.invalid
+				sf.mu.Unlock()
+				if invalid {
+					continue
+				}
+				return e.pdu, e.err
+			}
+		}
+		if len(sf.entries) >= readCacheSweepSize {
+			sf.sweep(now)
+		}
+		e := &cacheEntry{done: make(chan struct{})}
+		sf.entries[key] = e
+		sf.mu.Unlock()
+
+		e.pdu, e.err = send()
+		sf.mu.Lock()
+		e.expires = time.Now().Add(sf.ttl)
+		// errors are not cached, and it may be invalidated by a write meanwhile
+		if e.err != nil && sf.entries[key] == e {
+			delete(sf.entries, key)
+		}
+		close(e.done)
+		sf.mu.Unlock()
+		return e.pdu, e.err
+	}
+}
+
+// invalidate 使被写入范围影响的缓存失效, 广播写使provider所有从站的缓存失效,
+// 正在进行的请求应答后不再缓存, 等待它的请求重新请求.
+func (sf *readCache) invalidate(provider ClientProvider, slaveID, funcCode byte, address, quantity uint16) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for key, e := range sf.entries {
+		if key.provider != provider || key.funcCode != funcCode ||
+			(slaveID != AddressBroadCast && key.slaveID != slaveID) {
+			continue
+		}
+		if _, _, ok := overlap(key.address, key.quantity, address, quantity); ok {
+			e.invalid = true
+			delete(sf.entries, key)
+		}
+	}
+}
+
+// sweep 清理过期条目, 必须持有mu.
+func (sf *readCache) sweep(now time.Time) {
+	for key, e := range sf.entries {
+		select {
+		case <-e.done:
+			if !now.Before(e.expires) {
+				delete(sf.entries, key)
+			}
+		default:
+		}
+	}
+}
+
+// cacheableRead 返回发往provider的可缓存的读请求
+func cacheableRead(provider ClientProvider, slaveID byte, pdu []byte) (readKey, bool) {
+	if slaveID == AddressBroadCast || len(pdu) != 1+FuncReadMinSize {
+		return readKey{}, false
+	}
+	switch pdu[0] {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		return readKey{
+			provider,
+			slaveID,
+			pdu[0],
+			binary.BigEndian.Uint16(pdu[1:]),
+			binary.BigEndian.Uint16(pdu[3:]),
+		}, true
+	default:
+		return readKey{}, false
+	}
+}
+
+// writeRange 返回写请求影响的读功能码和写入范围
+func writeRange(pdu []byte) (funcCode byte, address, quantity uint16, ok bool) {
+	if len(pdu) < 1+FuncWriteMinSize {
+		return 0, 0, 0, false
+	}
+	address = binary.BigEndian.Uint16(pdu[1:])
+	switch pdu[0] {
+	case FuncCodeWriteSingleCoil:
+		return FuncCodeReadCoils, address, 1, true
+	case FuncCodeWriteMultipleCoils:
+		return FuncCodeReadCoils, address, binary.BigEndian.Uint16(pdu[3:]), true
+	case FuncCodeWriteSingleRegister, FuncCodeMaskWriteRegister:
+		return FuncCodeReadHoldingRegisters, address, 1, true
+	case FuncCodeWriteMultipleRegisters:
+		return FuncCodeReadHoldingRegisters, address, binary.BigEndian.Uint16(pdu[3:]), true
+	case FuncCodeReadWriteMultipleRegisters:
+		if len(pdu) < 1+FuncReadWriteMinSize {
+			return 0, 0, 0, false
+		}
+		return FuncCodeReadHoldingRegisters, binary.BigEndian.Uint16(pdu[5:]), binary.BigEndian.Uint16(pdu[7:]), true
+	default:
+		return 0, 0, 0, false
+	}
+}
@@ -0,0 +1,182 @@
+package modbus
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTCPGateway_SetReadCache(t *testing.T) {
+	// responds the count of the requests as register value
+	downstream := &fakeProvider{
+		delay: 20 * time.Millisecond,
+		respond: func(n int, _ byte, pdu []byte) []byte {
+			if pdu[0] != FuncCodeReadHoldingRegisters {
+				return pdu
+			}
+			return []byte{pdu[0], 0x02, 0x00, byte(n)}
+		},
+	}
+	gw := NewTCPGateway().SetReadCache(100 * time.Millisecond)
+	if err := gw.AddRoute(1, 1, downstream); err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+
+	read := []byte{FuncCodeReadHoldingRegisters, 0x00, 0x02, 0x00, 0x01}
+	relay := func(pdu []byte, want []byte, count int) {
+		t.Helper()
+		got, err := gw.relay(0x01, pdu)
+		if err != nil {
+			t.Fatalf("relay() error = %v, wantErr %v", err, nil)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("relay() = % x, want % x", got, want)
+		}
+		if n := len(downstream.received()); n != count {
+			t.Errorf("downstream requests = %v, want %v", n, count)
+		}
+	}
+
+	// concurrent identical reads are collapsed
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := gw.relay(0x01, read); err != nil || got[3] != 1 {
+				t.Errorf("relay() = % x, %v, want the first response", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+	relay(read, []byte{0x03, 0x02, 0x00, 0x01}, 1)
+	// other range is not cached
+	relay([]byte{FuncCodeReadHoldingRegisters, 0x00, 0x03, 0x00, 0x01}, []byte{0x03, 0x02, 0x00, 0x02}, 2)
+	// write out of the range keeps the cache
+	relay([]byte{FuncCodeWriteSingleRegister, 0x00, 0x05, 0x00, 0x01}, []byte{0x06, 0x00, 0x05, 0x00, 0x01}, 3)
+	relay(read, []byte{0x03, 0x02, 0x00, 0x01}, 3)
+	// overlapped write invalidates
+	relay([]byte{FuncCodeWriteMultipleRegisters, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x00, 0x00, 0x00},
+		[]byte{0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x00, 0x00, 0x00}, 4)
+	relay(read, []byte{0x03, 0x02, 0x00, 0x05}, 5)
+	// expired
+	time.Sleep(100 * time.Millisecond)
+	relay(read, []byte{0x03, 0x02, 0x00, 0x06}, 6)
+}
+
+func TestTCPGateway_SetReadCache_aliased(t *testing.T) {
+	// responds the count of the requests as register value
+	downstream := &fakeProvider{
+		respond: func(n int, _ byte, pdu []byte) []byte {
+			if pdu[0] != FuncCodeReadHoldingRegisters {
+				return pdu
+			}
+			return []byte{pdu[0], 0x02, 0x00, byte(n)}
+		},
+	}
+	gw := NewTCPGateway().SetReadCache(time.Minute)
+	defer gw.Close()
+	// unit 0x11 holdings 100~109 and unit 0x12 holdings 0~9 are both unit 0x01 holdings 0~9
+	for _, rule := range []GatewayRule{
+		{UnitID: 0x11, Provider: downstream, TargetUnitID: 0x01,
+			Tables: map[Table]AddressMapping{TableHoldings: {Start: 100, Quantity: 10, Offset: -100}}},
+		{UnitID: 0x12, Provider: downstream, TargetUnitID: 0x01,
+			Tables: map[Table]AddressMapping{TableHoldings: {Start: 0, Quantity: 10, Offset: 0}}},
+	} {
+		if err := gw.AddRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	relay := func(unitID byte, pdu []byte, want []byte, count int) {
+		t.Helper()
+		got, err := gw.relay(unitID, pdu)
+		if err != nil {
+			t.Fatalf("relay() error = %v, wantErr %v", err, nil)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("relay() = % x, want % x", got, want)
+		}
+		if n := len(downstream.received()); n != count {
+			t.Errorf("downstream requests = %v, want %v", n, count)
+		}
+	}
+	relay(0x12, []byte{FuncCodeReadHoldingRegisters, 0x00, 0x02, 0x00, 0x01}, []byte{0x03, 0x02, 0x00, 0x01}, 1)
+	// the same register of the device through the other rule
+	relay(0x11, []byte{FuncCodeReadHoldingRegisters, 0x00, 0x66, 0x00, 0x01}, []byte{0x03, 0x02, 0x00, 0x01}, 1)
+	// a write through one rule invalidates the read through the other
+	relay(0x11, []byte{FuncCodeWriteSingleRegister, 0x00, 0x66, 0x12, 0x34}, []byte{0x06, 0x00, 0x66, 0x12, 0x34}, 2)
+	relay(0x12, []byte{FuncCodeReadHoldingRegisters, 0x00, 0x02, 0x00, 0x01}, []byte{0x03, 0x02, 0x00, 0x03}, 3)
+}
+
+func Test_readCache_invalidateInFlight(t *testing.T) {
+	cache := newReadCache(time.Minute)
+	key, _ := cacheableRead(nil, 0x01, []byte{FuncCodeReadHoldingRegisters, 0x00, 0x02, 0x00, 0x01})
+	before, after := []byte{0x03, 0x02, 0x00, 0x01}, []byte{0x03, 0x02, 0x00, 0x02}
+
+	release := make(chan struct{})
+	first := make(chan []byte, 1)
+	go func() {
+		pdu, _ := cache.do(key, func() ([]byte, error) {
+			<-release
+			return before, nil
+		})
+		first <- pdu
+	}()
+	for inFlight := false; !inFlight; time.Sleep(time.Millisecond) {
+		cache.mu.Lock()
+		_, inFlight = cache.entries[key]
+		cache.mu.Unlock()
+	}
+	waiter := make(chan []byte, 1)
+	go func() {
+		pdu, _ := cache.do(key, func() ([]byte, error) { return after, nil })
+		waiter <- pdu
+	}()
+	time.Sleep(20 * time.Millisecond) // joined the in flight read
+	cache.invalidate(nil, 0x01, FuncCodeReadHoldingRegisters, 2, 1)
+	close(release)
+
+	if got := <-first; !reflect.DeepEqual(got, before) {
+		t.Errorf("do() = % x, want % x", got, before)
+	}
+	if got := <-waiter; !reflect.DeepEqual(got, after) {
+		t.Errorf("waiter do() = % x, want the read after the write % x", got, after)
+	}
+}
+
+func TestTCPGateway_SetReadCache_broadcast(t *testing.T) {
+	// responds the count of the requests as register value
+	downstream := &fakeProvider{
+		respond: func(n int, _ byte, pdu []byte) []byte {
+			if pdu[0] != FuncCodeReadHoldingRegisters {
+				return pdu
+			}
+			return []byte{pdu[0], 0x02, 0x00, byte(n)}
+		},
+	}
+	gw := NewTCPGateway().SetReadCache(time.Minute)
+	defer gw.Close()
+	if err := gw.AddRoute(0, 2, downstream); err != nil {
+		t.Fatal(err)
+	}
+
+	read := []byte{FuncCodeReadHoldingRegisters, 0x00, 0x02, 0x00, 0x01}
+	for _, unitID := range []byte{1, 2} {
+		if _, err := gw.relay(unitID, read); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := gw.relay(AddressBroadCast, []byte{FuncCodeWriteSingleRegister, 0x00, 0x02, 0x12, 0x34}); err != nil {
+		t.Fatal(err)
+	}
+	// every slave on the bus is written
+	for i, unitID := range []byte{1, 2} {
+		got, err := gw.relay(unitID, read)
+		if want := []byte{0x03, 0x02, 0x00, byte(4 + i)}; err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("relay() unit %d = % x, %v, want % x", unitID, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// AddressMapping 表的地址映射, 只允许访问请求地址范围[Start, Start+Quantity),
+// 转发时地址加Offset, 超出范围的请求应答异常0x02.
+type AddressMapping struct {
+	Start    uint16
+	Quantity uint16
+	Offset   int32 // downstream address = request address + Offset
+}
+
+// GatewayRule 网关路由规则, 将请求的从站地址UnitID转发到Provider的从站地址
+// TargetUnitID, 并按表转换地址, Tables中没有的表不转换不限制.
+type GatewayRule struct {
+	UnitID       byte
+	Provider     ClientProvider
+	TargetUnitID byte
+	Tables       map[Table]AddressMapping
+}
+
+// AddRule 添加路由规则, 规则的从站地址不可与已有路由重叠, 同一Provider的
+// 路由和规则共用一个请求队列.
+func (sf *TCPGateway) AddRule(rule GatewayRule) error {
+	if rule.Provider == nil {
+		return errors.New("modbus: gateway rule provider is nil")
+	}
+	tables := make(map[Table]AddressMapping, len(rule.Tables))
+	for table, m := range rule.Tables {
+		tables[table] = m
+	}
+	rule.Tables = tables
+	return sf.addRoute(&gatewayRoute{first: rule.UnitID, last: rule.UnitID, rule: &rule}, rule.Provider)
+}
+
+// pduAddress 请求pdu中的一个地址域
+type pduAddress struct {
+	table    Table
+	pos      int // index of the address in pdu
+	quantity uint16
+}
+
+// requestAddresses 返回请求pdu中的地址域, 不含地址的功能码返回nil,
+// 长度不足返回异常0x03.
+func requestAddresses(pdu []byte) ([]pduAddress, error) {
+	var table Table
+	var minSize int
+	funcCode := pdu[0]
+	switch funcCode {
+	case FuncCodeReadCoils, FuncCodeWriteSingleCoil, FuncCodeWriteMultipleCoils:
+		table, minSize = TableCoils, FuncReadMinSize
+	case FuncCodeReadDiscreteInputs:
+		table, minSize = TableDiscretes, FuncReadMinSize
+	case FuncCodeReadInputRegisters:
+		table, minSize = TableInputs, FuncReadMinSize
+	case FuncCodeReadHoldingRegisters, FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleRegisters, FuncCodeMaskWriteRegister:
+		table, minSize = TableHoldings, FuncReadMinSize
+	case FuncCodeReadWriteMultipleRegisters:
+		table, minSize = TableHoldings, FuncReadWriteMinSize
+	default:
+		return nil, nil
+	}
+	if len(pdu) < 1+minSize {
+		return nil, &ExceptionError{ExceptionCodeIllegalDataValue}
+	}
+
+	switch funcCode {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister, FuncCodeMaskWriteRegister:
+		return []pduAddress{{table, 1, 1}}, nil
+	case FuncCodeReadWriteMultipleRegisters:
+		return []pduAddress{
+			{table, 1, binary.BigEndian.Uint16(pdu[3:])},
+			{table, 5, binary.BigEndian.Uint16(pdu[7:])},
+		}, nil
+	default:
+		return []pduAddress{{table, 1, binary.BigEndian.Uint16(pdu[3:])}}, nil
+	}
+}
+
+// translate 返回转换地址后的请求pdu, 超出允许范围返回异常0x02.
+func (sf *GatewayRule) translate(pdu []byte) ([]byte, error) {
+	fields, err := requestAddresses(pdu)
+	if err != nil || len(fields) == 0 {
+		return pdu, err
+	}
+
+	request := append([]byte(nil), pdu...)
+	for _, field := range fields {
+		m, ok := sf.Tables[field.table]
+		if !ok {
+			continue
+		}
+		address := uint32(binary.BigEndian.Uint16(pdu[field.pos:]))
+		end := address + uint32(field.quantity)
+		if address < uint32(m.Start) || end > uint32(m.Start)+uint32(m.Quantity) {
+			return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
+		}
+		target := int64(address) + int64(m.Offset)
+		if target < 0 || target+int64(field.quantity) > 0x10000 {
+			return nil, &ExceptionError{ExceptionCodeIllegalDataAddress}
+		}
+		binary.BigEndian.PutUint16(request[field.pos:], uint16(target))
+	}
+	return request, nil
+}
+
+// restoreAddress 写应答回显的地址恢复为请求的地址
+func restoreAddress(request, response []byte) []byte {
+	if len(response) == 0 { // broadcast
+		return response
+	}
+	switch response[0] {
+	case FuncCodeWriteSingleCoil, FuncCodeWriteMultipleCoils,
+		FuncCodeWriteSingleRegister, FuncCodeWriteMultipleRegisters, FuncCodeMaskWriteRegister:
+		if len(response) >= 3 && len(request) >= 3 {
+			response = append([]byte(nil), response...)
+			copy(response[1:3], request[1:3])
+		}
+	}
+	return response
+}
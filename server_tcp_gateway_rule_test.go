@@ -0,0 +1,79 @@
+package modbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTCPGateway_AddRule(t *testing.T) {
+	downstream := &fakeProvider{}
+	gw := NewTCPGateway()
+	defer gw.Close()
+	err := gw.AddRule(GatewayRule{
+		UnitID:       0x11,
+		Provider:     downstream,
+		TargetUnitID: 0x01,
+		Tables: map[Table]AddressMapping{
+			TableHoldings: {Start: 100, Quantity: 10, Offset: -100},
+			TableCoils:    {Start: 0, Quantity: 16, Offset: 1000},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gw.AddRoute(0x10, 0x20, downstream); err == nil {
+		t.Errorf("AddRoute() overlapped error = %v, wantErr %v", err, true)
+	}
+
+	tests := []struct {
+		name        string
+		request     []byte
+		want        []byte
+		wantRequest []byte
+		wantErr     byte
+	}{
+		{"read holdings",
+			[]byte{0x03, 0x00, 0x64, 0x00, 0x0a}, []byte{0x03, 0x00, 0x00, 0x00, 0x0a},
+			[]byte{0x03, 0x00, 0x00, 0x00, 0x0a}, 0},
+		{"write coil echo restored",
+			[]byte{0x05, 0x00, 0x02, 0xff, 0x00}, []byte{0x05, 0x00, 0x02, 0xff, 0x00},
+			[]byte{0x05, 0x03, 0xea, 0xff, 0x00}, 0},
+		{"inputs not mapped",
+			[]byte{0x04, 0x00, 0x64, 0x00, 0x01}, []byte{0x04, 0x00, 0x64, 0x00, 0x01},
+			[]byte{0x04, 0x00, 0x64, 0x00, 0x01}, 0},
+		{"read write both translated",
+			[]byte{0x17, 0x00, 0x65, 0x00, 0x01, 0x00, 0x66, 0x00, 0x01, 0x02, 0x12, 0x34},
+			[]byte{0x17, 0x00, 0x01, 0x00, 0x01, 0x00, 0x02, 0x00, 0x01, 0x02, 0x12, 0x34},
+			[]byte{0x17, 0x00, 0x01, 0x00, 0x01, 0x00, 0x02, 0x00, 0x01, 0x02, 0x12, 0x34}, 0},
+		{"out of range",
+			[]byte{0x03, 0x00, 0x6e, 0x00, 0x01}, nil, nil, ExceptionCodeIllegalDataAddress},
+		{"partly out of range",
+			[]byte{0x10, 0x00, 0x6d, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02}, nil, nil, ExceptionCodeIllegalDataAddress},
+		{"too short",
+			[]byte{0x03, 0x00, 0x64}, nil, nil, ExceptionCodeIllegalDataValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			downstream.reset()
+			got, err := gw.relay(0x11, tt.request)
+			if tt.wantErr != 0 {
+				if code := exceptionCode(err); err == nil || code != tt.wantErr {
+					t.Errorf("relay() exception = %v, want %v", err, tt.wantErr)
+				}
+				if len(downstream.received()) != 0 {
+					t.Errorf("rejected request should not reach the device")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("relay() error = %v, wantErr %v", err, nil)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relay() = % x, want % x", got, tt.want)
+			}
+			if got := downstream.received(); len(got) != 1 || got[0].slaveID != 0x01 || !reflect.DeepEqual(got[0].pdu, tt.wantRequest) {
+				t.Errorf("device got requests %v, want slave 1 request % x", got, tt.wantRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRequest a request received by fakeProvider
+type fakeRequest struct {
+	slaveID byte
+	pdu     []byte
+}
+
+// fakeProvider 可配置的下游设备, 记录收到的请求, 默认原样应答请求pdu.
+type fakeProvider struct {
+	provider
+	delay    time.Duration                                // respond after delay
+	release  chan struct{}                                // if not nil, requests block until it is closed
+	respond  func(n int, slaveID byte, pdu []byte) []byte // n is the count of the requests, nil echoes pdu
+	mu       sync.Mutex
+	requests []fakeRequest
+}
+
+func (sf *fakeProvider) SendPdu(slaveID byte, pdu []byte) ([]byte, error) {
+	sf.mu.Lock()
+	sf.requests = append(sf.requests, fakeRequest{slaveID, append([]byte(nil), pdu...)})
+	n := len(sf.requests)
+	sf.mu.Unlock()
+	if sf.release != nil {
+		<-sf.release
+	}
+	time.Sleep(sf.delay)
+	if sf.respond == nil {
+		return pdu, nil
+	}
+	return sf.respond(n, slaveID, pdu), nil
+}
+
+// received returns the requests received so far.
+func (sf *fakeProvider) received() []fakeRequest {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return append([]fakeRequest(nil), sf.requests...)
+}
+
+// reset forgets the requests received.
+func (sf *fakeProvider) reset() {
+	sf.mu.Lock()
+	sf.requests = nil
+	sf.mu.Unlock()
+}
+
+// rtuSlave answers read holding registers of slave 0x01 with 0x1234,
+// and illegal data address exception for the others.
+func rtuSlave(port io.ReadWriter) {
+	for {
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(port, request); err != nil {
+			return
+		}
+		response := []byte{request[0], 0x83, 0x02}
+		if request[0] == 0x01 {
+			response = []byte{request[0], 0x03, 0x02, 0x12, 0x34}
+		}
+		checksum := CRC16(response)
+		if _, err := port.Write(append(response, byte(checksum), byte(checksum>>8))); err != nil {
+			return
+		}
+	}
+}
+
+// exchangeTCP writes request adu to conn, returns the response adu.
+func exchangeTCP(t *testing.T, conn net.Conn, request []byte) []byte {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, tcpHeaderMbapSize)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatal(err)
+	}
+	length := int(binary.BigEndian.Uint16(response[4:])) - 1
+	response = append(response, make([]byte, length)...)
+	if _, err := io.ReadFull(conn, response[tcpHeaderMbapSize:]); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestTCPGateway(t *testing.T) {
+	port := newPipePort()
+	defer port.Close()
+	go rtuSlave(port.remote)
+	rtu := NewRTUClientProvider()
+	rtu.port = port
+
+	gw := NewTCPGateway().SetRequestTimeout(100 * time.Millisecond)
+	if err := gw.AddRoute(1, 10, rtu); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.AddRoute(20, 20, &fakeProvider{
+		delay:   200 * time.Millisecond,
+		respond: func(int, byte, []byte) []byte { return []byte{0x03, 0x02, 0x00, 0x00} },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.AddRoute(10, 15, rtu); err == nil {
+		t.Errorf("AddRoute() overlapped error = %v, wantErr %v", err, true)
+	}
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = gw.Serve(listen) }()
+	defer gw.Close()
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tests := []struct {
+		name    string
+		request []byte
+		want    []byte
+	}{
+		{"relay response",
+			[]byte{0x12, 0x34, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01},
+			[]byte{0x12, 0x34, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x12, 0x34}},
+		{"relay exception",
+			[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x02, 0x03, 0x00, 0x00, 0x00, 0x01},
+			[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x02, 0x83, 0x02}},
+		{"target failed to respond",
+			[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x06, 0x14, 0x03, 0x00, 0x00, 0x00, 0x01},
+			[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x03, 0x14, 0x83, 0x0b}},
+		{"path unavailable",
+			[]byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x06, 0x1e, 0x03, 0x00, 0x00, 0x00, 0x01},
+			[]byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x03, 0x1e, 0x83, 0x0a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exchangeTCP(t, conn, tt.request); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("response = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTCPGateway_relay_broadcastNoRoute(t *testing.T) {
+	gw := NewTCPGateway()
+	defer gw.Close()
+	if err := gw.AddRoute(1, 10, &fakeProvider{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := gw.relay(AddressBroadCast, []byte{FuncCodeWriteSingleRegister, 0x00, 0x01, 0x12, 0x34}); got != nil || err != nil {
+		t.Errorf("relay() broadcast = % x, %v, want no response", got, err)
+	}
+	if _, err := gw.relay(20, []byte{FuncCodeReadHoldingRegisters, 0x00, 0x01, 0x00, 0x01}); exceptionCode(err) != ExceptionCodeGatewayPathUnavailable {
+		t.Errorf("relay() no route error = %v, want exception %d", err, ExceptionCodeGatewayPathUnavailable)
+	}
+}
+
+func TestTCPGateway_SetRequestTimeout_noLimit(t *testing.T) {
+	gw := NewTCPGateway().SetRequestTimeout(0)
+	defer gw.Close()
+	if err := gw.AddRoute(1, 1, &fakeProvider{delay: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	request := []byte{FuncCodeWriteSingleRegister, 0x00, 0x01, 0x12, 0x34}
+	if got, err := gw.relay(1, request); err != nil || !reflect.DeepEqual(got, request) {
+		t.Errorf("relay() = % x, %v, want % x", got, err, request)
+	}
+}
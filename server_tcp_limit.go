@@ -0,0 +1,207 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// connection admission errors
+var (
+	errConnDenied         = errors.New("remote ip is not allowed")
+	errTooManyConns       = errors.New("too many connections")
+	errTooManyConnsFromIP = errors.New("too many connections from the remote ip")
+)
+
+// SetMaxConns set max concurrent connections, n <= 0 means no limit. When the
+// limit reached, a new connection evicts the oldest idle one if evict is true,
+// a session handling a request is not idle, it is rejected if none is idle
+// or evict is false.
+func (sf *TCPServer) SetMaxConns(n int, evict bool) *TCPServer {
+	sf.maxConns = n
+	sf.evictIdle = evict
+	return sf
+}
+
+// SetMaxConnsPerIP set max concurrent connections from the same remote ip,
+// n <= 0 means no limit, new connections over the limit are rejected.
+func (sf *TCPServer) SetMaxConnsPerIP(n int) *TCPServer {
+	sf.maxConnsPerIP = n
+	return sf
+}
+
+// SetIdleTimeout set how long a connection can idle between two requests,
+// distinct from the read timeout within a request, 0 means use read timeout.
+func (sf *TCPServer) SetIdleTimeout(t time.Duration) *TCPServer {
+	sf.idleTimeout = t
+	return sf
+}
+
+// SetFrameTimeout set the total receive deadline of a frame since its first
+// byte, a client that trickles bytes is disconnected when it expired,
+// 0 means no limit.
+func (sf *TCPServer) SetFrameTimeout(t time.Duration) *TCPServer {
+	sf.frameTimeout = t
+	return sf
+}
+
+// SetRateLimit set the request rate limit shared by all sessions, rate
+// requests per second with burst, rate <= 0 means no limit.
+func (sf *TCPServer) SetRateLimit(rate float64, burst int) *TCPServer {
+	sf.rateLimiter = newRateLimiter(rate, burst)
+	return sf
+}
+
+// SetSessionRateLimit set the request rate limit of each session, rate
+// requests per second with burst, rate <= 0 means no limit.
+func (sf *TCPServer) SetSessionRateLimit(rate float64, burst int) *TCPServer {
+	sf.sessionRate, sf.sessionBurst = rate, burst
+	return sf
+}
+
+// SetRateLimitPolicy set what to do with the request over the rate limit,
+// default RateLimitBusy.
+func (sf *TCPServer) SetRateLimitPolicy(p RateLimitPolicy) *TCPServer {
+	sf.ratePolicy = p
+	return sf
+}
+
+// SetAllowList set the CIDR allow list, such as "192.168.1.0/24", if it is
+// not empty, only the remote ip within it can connect.
+func (sf *TCPServer) SetAllowList(cidr ...string) error {
+	nets, err := parseCIDRs(cidr)
+	if err != nil {
+		return err
+	}
+	sf.allowList = nets
+	return nil
+}
+
+// SetDenyList set the CIDR deny list, the remote ip within it can not
+// connect, it takes precedence over the allow list.
+func (sf *TCPServer) SetDenyList(cidr ...string) error {
+	nets, err := parseCIDRs(cidr)
+	if err != nil {
+		return err
+	}
+	sf.denyList = nets
+	return nil
+}
+
+// ConnCount returns the number of current connections.
+func (sf *TCPServer) ConnCount() int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return len(sf.sessions)
+}
+
+func parseCIDRs(cidr []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidr))
+	for _, s := range cidr {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the ip of the remote address, empty if it is not a ip address.
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// admit checks the allow,deny list and connection limits, then tracks the
+// session, ctx is the context of the server which the session belongs to.
+func (sf *TCPServer) admit(ctx context.Context, sess *ServerSession) error {
+	ip := remoteIP(sess.conn)
+	if ip != nil && (containsIP(sf.denyList, ip) ||
+		len(sf.allowList) > 0 && !containsIP(sf.allowList, ip)) {
+		return errConnDenied
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if ctx.Err() != nil {
+		return ErrServerClosed
+	}
+	key := ip.String()
+	if sf.maxConnsPerIP > 0 && sf.connsPerIP[key] >= sf.maxConnsPerIP {
+		return errTooManyConnsFromIP
+	}
+	if sf.maxConns > 0 && len(sf.sessions) >= sf.maxConns {
+		if !sf.evictIdle {
+			return errTooManyConns
+		}
+		var oldest *ServerSession
+		for s := range sf.sessions {
+			if atomic.LoadInt32(&s.state) != sessionIdle { // busy with a request
+				continue
+			}
+			if oldest == nil || s.lastActiveTime() < oldest.lastActiveTime() {
+				oldest = s
+			}
+		}
+		if oldest == nil {
+			return errTooManyConns
+		}
+		sf.Debugf("evict idle client(%v)", oldest.conn.RemoteAddr())
+		sf.untrack(oldest)
+		oldest.conn.Close()
+	}
+	if sf.sessions == nil {
+		sf.sessions = make(map[*ServerSession]string)
+		sf.connsPerIP = make(map[string]int)
+	}
+	sf.sessions[sess] = key
+	sf.connsPerIP[key]++
+	sf.wg.Add(1)
+	return nil
+}
+
+// release untracks the session when it exits.
+func (sf *TCPServer) release(sess *ServerSession) {
+	sf.mu.Lock()
+	sf.untrack(sess)
+	sf.mu.Unlock()
+}
+
+// untrack must be called with mu held, it is safe to call repeatedly.
+func (sf *TCPServer) untrack(sess *ServerSession) {
+	key, ok := sf.sessions[sess]
+	if !ok {
+		return
+	}
+	delete(sf.sessions, sess)
+	if sf.connsPerIP[key]--; sf.connsPerIP[key] <= 0 {
+		delete(sf.connsPerIP, key)
+	}
+}
+
+// touch marks the session active now.
+func (sf *ServerSession) touch() {
+	atomic.StoreInt64(&sf.lastActive, time.Now().UnixNano())
+}
+
+func (sf *ServerSession) lastActiveTime() int64 {
+	return atomic.LoadInt64(&sf.lastActive)
+}
@@ -0,0 +1,211 @@
+package modbus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveTCP starts srv on a random local port and returns its address.
+func serveTCP(t *testing.T, srv *TCPServer) string {
+	t.Helper()
+	srv.AddNodes(NewNodeRegister(0x01, 0, 10, 0, 10, 0, 10, 0, 10))
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(listen) }()
+	return listen.Addr().String()
+}
+
+// readCoilsRaw sends read coils request on conn, reports whether it is served.
+func readCoilsRaw(conn net.Conn) bool {
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x01, 0x00, 0x00, 0x00, 0x08}); err != nil {
+		return false
+	}
+	_, err := io.ReadFull(conn, make([]byte, 10))
+	return err == nil
+}
+
+// closedByServer reports whether the server closed conn.
+func closedByServer(conn net.Conn) bool {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	// reset if the server closed it with unread data
+	var ne net.Error
+	return err == io.EOF || (err != nil && !(errors.As(err, &ne) && ne.Timeout()))
+}
+
+func TestTCPServer_SetMaxConns(t *testing.T) {
+	for _, evict := range []bool{false, true} {
+		srv := NewTCPServer().SetMaxConns(1, evict)
+		addr := serveTCP(t, srv)
+
+		c1, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !readCoilsRaw(c1) {
+			t.Fatalf("evict %v: first connection not served", evict)
+		}
+		c2, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if evict {
+			if !closedByServer(c1) || !readCoilsRaw(c2) {
+				t.Errorf("evict %v: oldest idle connection should be evicted", evict)
+			}
+		} else if !closedByServer(c2) || !readCoilsRaw(c1) {
+			t.Errorf("evict %v: new connection should be rejected", evict)
+		}
+		c1.Close()
+		c2.Close()
+		srv.Close()
+	}
+}
+
+func TestTCPServer_SetMaxConns_busy(t *testing.T) {
+	srv := NewTCPServer().SetMaxConns(1, true)
+	started, release := make(chan struct{}), make(chan struct{})
+	srv.RegisterFunctionHandler(0x41, func(NodeStorage, []byte) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte{0x00}, nil
+	})
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+
+	c1, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	if _, err = c1.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x01, 0x41}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	// the only session is handling a request, it is not evicted
+	c2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if !closedByServer(c2) {
+		t.Errorf("new connection should be rejected")
+	}
+	close(release)
+	_ = c1.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = io.ReadFull(c1, make([]byte, 9)); err != nil {
+		t.Errorf("busy connection should be served, %v", err)
+	}
+}
+
+func TestTCPServer_SetDenyList(t *testing.T) {
+	srv := NewTCPServer()
+	if err := srv.SetAllowList("10.0.0.0/8", "127.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetDenyList("127.0.0.1/32"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SetDenyList("127.0.0.1"); err == nil {
+		t.Errorf("SetDenyList() error = %v, wantErr %v", err, true)
+	}
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !closedByServer(conn) {
+		t.Errorf("denied connection should be closed")
+	}
+}
+
+func TestTCPServer_SetIdleTimeout(t *testing.T) {
+	srv := NewTCPServer().SetIdleTimeout(50 * time.Millisecond).SetMaxConnsPerIP(1)
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !readCoilsRaw(conn) {
+		t.Fatalf("connection not served")
+	}
+	other, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if !closedByServer(other) {
+		t.Errorf("connection over per ip limit should be closed")
+	}
+	if !closedByServer(conn) {
+		t.Errorf("idle connection should be closed")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := srv.ConnCount(); n != 0 {
+		t.Errorf("ConnCount() = %v, want %v", n, 0)
+	}
+}
+
+func TestTCPServer_SetSessionRateLimit(t *testing.T) {
+	srv := NewTCPServer().SetSessionRateLimit(0.1, 1)
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	request := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	var response []byte
+	for i, want := range []byte{0x03, 0x83} {
+		response = make([]byte, 11-i*2) // exception response is shorter
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err = conn.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.ReadFull(conn, response); err != nil {
+			t.Fatal(err)
+		}
+		if response[7] != want {
+			t.Errorf("request %d function code = %#x, want %#x", i, response[7], want)
+		}
+	}
+	if response[8] != ExceptionCodeServerDeviceBusy {
+		t.Errorf("exception code = %v, want %v", response[8], ExceptionCodeServerDeviceBusy)
+	}
+}
+
+func TestTCPServer_SetFrameTimeout(t *testing.T) {
+	srv := NewTCPServer().SetFrameTimeout(50 * time.Millisecond)
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// trickle bytes slower than the frame timeout
+	for _, b := range []byte{0x00, 0x01, 0x00} {
+		if _, err = conn.Write([]byte{b}); err != nil {
+			break
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+	if !closedByServer(conn) {
+		t.Errorf("slow client should be closed")
+	}
+}
@@ -0,0 +1,111 @@
+package modbus
+
+import (
+	"sync"
+	"time"
+)
+
+// UnmatchedPolicy 回放时没有录制的请求的处理
+type UnmatchedPolicy byte
+
+// unmatched policies
+const (
+	// UnmatchedException 应答异常, 异常码由SetUnmatchedException设置
+	UnmatchedException UnmatchedPolicy = iota
+	// UnmatchedNoResponse 不应答, 客户端超时
+	UnmatchedNoResponse
+)
+
+// ReplayServer modbus tcp 回放服务器, 以Recorder录制的应答回复相同的请求(从站
+// 地址和请求pdu都相同), 不使用本地节点, 用于没有设备时的集成测试.
+// 同一请求录制了多次时按录制顺序依次应答, 之后一直应答最后一次的;
+// 录制时没有应答的请求(广播或超时)回放时也不应答.
+type ReplayServer struct {
+	*TCPServer
+	mu        sync.Mutex
+	exchanges map[string][]RecordedExchange
+	next      map[string]int
+	policy    UnmatchedPolicy
+	code      byte
+	unmatched []RecordedExchange
+}
+
+// NewReplayServer new modbus tcp replay server of the recorded exchanges,
+// see ReadRecording and LoadRecording.
+func NewReplayServer(exchanges []RecordedExchange) *ReplayServer {
+	sf := &ReplayServer{
+		TCPServer: NewTCPServer(),
+		exchanges: make(map[string][]RecordedExchange),
+		next:      make(map[string]int),
+		code:      ExceptionCodeServerDeviceFailure,
+	}
+	for _, ex := range exchanges {
+		key := replayKey(ex.SlaveID, ex.Request)
+		sf.exchanges[key] = append(sf.exchanges[key], ex)
+	}
+	sf.TCPServer.logger = newLogger("modbusReplayServer => ")
+	sf.forward = sf.replay
+	return sf
+}
+
+// SetUnmatchedPolicy set what to do with the request not recorded, default UnmatchedException.
+func (sf *ReplayServer) SetUnmatchedPolicy(p UnmatchedPolicy) *ReplayServer {
+	sf.mu.Lock()
+	sf.policy = p
+	sf.mu.Unlock()
+	return sf
+}
+
+// SetUnmatchedException set the exception code replied to the request not
+// recorded with UnmatchedException, default ExceptionCodeServerDeviceFailure.
+func (sf *ReplayServer) SetUnmatchedException(code byte) *ReplayServer {
+	sf.mu.Lock()
+	sf.code = code
+	sf.mu.Unlock()
+	return sf
+}
+
+// Unmatched returns the requests not recorded in the order received, Response is nil.
+func (sf *ReplayServer) Unmatched() []RecordedExchange {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return append([]RecordedExchange(nil), sf.unmatched...)
+}
+
+// Rewind replay from the first recorded response of every request again,
+// and clear the unmatched requests.
+func (sf *ReplayServer) Rewind() {
+	sf.mu.Lock()
+	sf.next = make(map[string]int)
+	sf.unmatched = nil
+	sf.mu.Unlock()
+}
+
+// replay 查找录制的应答, 没有应答时返回nil
+func (sf *ReplayServer) replay(slaveID byte, pdu []byte) ([]byte, error) {
+	key := replayKey(slaveID, pdu)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	recorded := sf.exchanges[key]
+	if len(recorded) == 0 {
+		sf.Debugf("unmatched request slave %d [% x]", slaveID, pdu)
+		sf.unmatched = append(sf.unmatched, RecordedExchange{
+			Time:    time.Now(),
+			SlaveID: slaveID,
+			Request: append([]byte(nil), pdu...),
+		})
+		if sf.policy == UnmatchedNoResponse {
+			return nil, nil
+		}
+		return nil, &ExceptionError{sf.code}
+	}
+	i := sf.next[key]
+	if i < len(recorded)-1 {
+		sf.next[key] = i + 1
+	}
+	return append([]byte(nil), recorded[i].Response...), nil
+}
+
+func replayKey(slaveID byte, pdu []byte) string {
+	return string(append([]byte{slaveID}, pdu...))
+}
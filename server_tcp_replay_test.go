@@ -0,0 +1,87 @@
+package modbus
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplayServer(t *testing.T) {
+	srv := NewReplayServer([]RecordedExchange{
+		{SlaveID: 0x01, Request: []byte{0x03, 0x00, 0x00, 0x00, 0x01}, Response: []byte{0x03, 0x02, 0x00, 0x01}},
+		{SlaveID: 0x01, Request: []byte{0x03, 0x00, 0x00, 0x00, 0x01}, Response: []byte{0x03, 0x02, 0x00, 0x02}},
+		{SlaveID: 0x01, Request: []byte{0x03, 0x00, 0x14, 0x00, 0x01}, Response: []byte{0x83, 0x02}},
+		{SlaveID: 0x02, Request: []byte{0x03, 0x00, 0x00, 0x00, 0x01}, Err: "timeout"},
+	})
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(listen) }()
+	defer srv.Close()
+
+	client := NewClient(NewTCPClientProvider(listen.Addr().String(), WithTCPTimeout(100*time.Millisecond)))
+	if err = client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// replay in order, then the last one
+	for _, want := range []uint16{1, 2, 2} {
+		got, err := client.ReadHoldingRegisters(0x01, 0, 1)
+		if err != nil || !reflect.DeepEqual(got, []uint16{want}) {
+			t.Errorf("ReadHoldingRegisters() = %v, %v, want %v", got, err, want)
+		}
+	}
+	if _, err = client.ReadHoldingRegisters(0x01, 20, 1); !errors.Is(err, &ExceptionError{ExceptionCodeIllegalDataAddress}) {
+		t.Errorf("ReadHoldingRegisters() error = %v, want exception %d", err, ExceptionCodeIllegalDataAddress)
+	}
+	if _, err = client.ReadHoldingRegisters(0x01, 1, 1); !errors.Is(err, &ExceptionError{ExceptionCodeServerDeviceFailure}) {
+		t.Errorf("ReadHoldingRegisters() unmatched error = %v, want exception %d", err, ExceptionCodeServerDeviceFailure)
+	}
+	srv.SetUnmatchedException(ExceptionCodeIllegalFunction)
+	if err = client.WriteSingleRegister(0x01, 1, 1); !errors.Is(err, &ExceptionError{ExceptionCodeIllegalFunction}) {
+		t.Errorf("WriteSingleRegister() unmatched error = %v, want exception %d", err, ExceptionCodeIllegalFunction)
+	}
+	unmatched := srv.Unmatched()
+	if len(unmatched) != 2 || !reflect.DeepEqual(unmatched[1].Request, []byte{0x06, 0x00, 0x01, 0x00, 0x01}) {
+		t.Errorf("Unmatched() = %+v", unmatched)
+	}
+
+	srv.Rewind()
+	if got, err := client.ReadHoldingRegisters(0x01, 0, 1); err != nil || got[0] != 1 {
+		t.Errorf("ReadHoldingRegisters() after Rewind = %v, %v, want %v", got, err, 1)
+	}
+	if len(srv.Unmatched()) != 0 {
+		t.Errorf("Unmatched() after Rewind = %+v, want empty", srv.Unmatched())
+	}
+}
+
+func TestReplayServer_noResponse(t *testing.T) {
+	srv := NewReplayServer([]RecordedExchange{
+		{SlaveID: 0x02, Request: []byte{0x03, 0x00, 0x00, 0x00, 0x01}, Err: "timeout"},
+	}).SetUnmatchedPolicy(UnmatchedNoResponse)
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(listen) }()
+	defer srv.Close()
+
+	for _, address := range []uint16{0, 1} { // recorded without response, unmatched
+		conn, err := net.Dial("tcp", listen.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err = conn.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x02, 0x03, 0x00, byte(address), 0x00, 0x01}); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := conn.Read(make([]byte, 16)); err == nil {
+			t.Errorf("address %d got %d bytes response, want no response", address, n)
+		}
+		conn.Close()
+	}
+}
@@ -8,29 +8,64 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// session states
+const (
+	sessionBusy   int32 = iota // receiving or handling a request
+	sessionIdle                // waiting for a request
+	sessionClosed              // closed by shutdown
+)
+
 // ServerSession tcp server session
 type ServerSession struct {
+	lastActive   int64 // unix nano, atomic
+	state        int32 // atomic
 	conn         net.Conn
 	readTimeout  time.Duration
 	writeTimeout time.Duration
+	idleTimeout  time.Duration // between two requests, 0 means use readTimeout
+	frameTimeout time.Duration // whole frame since its first byte, 0 means no limit
+	limiter      *rateLimiter  // per session, nil means no limit
+	global       *rateLimiter  // shared by all sessions, nil means no limit
+	ratePolicy   RateLimitPolicy
+	capturer
 	*serverCommon
 	logger
 }
 
+// LocalAddr returns the local network address of the session.
+func (sf *ServerSession) LocalAddr() net.Addr {
+	if sf.conn == nil {
+		return nil
+	}
+	return sf.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address of the session.
+func (sf *ServerSession) RemoteAddr() net.Addr {
+	if sf.conn == nil {
+		return nil
+	}
+	return sf.conn.RemoteAddr()
+}
+
 // handler net conn
 func (sf *ServerSession) running(ctx context.Context) {
 	var err error
 	var bytesRead int
 
 	sf.Debugf("client(%v) -> server(%v) connected", sf.conn.RemoteAddr(), sf.conn.LocalAddr())
+	sf.metrics.sessionOpened()
 	defer func() {
+		sf.metrics.sessionClosed()
 		sf.conn.Close()
 		sf.Debugf("client(%v) -> server(%v) disconnected,cause by %v", sf.conn.RemoteAddr(), sf.conn.LocalAddr(), err)
 	}()
 
+	var frameDeadline time.Time
 	raw := make([]byte, tcpAduMaxSize)
 	for {
 		select {
@@ -42,11 +77,32 @@ func (sf *ServerSession) running(ctx context.Context) {
 
 		adu := raw
 		for rdCnt, length := 0, tcpHeaderMbapSize; rdCnt < length; {
-			err = sf.conn.SetReadDeadline(time.Now().Add(sf.readTimeout))
+			timeout := sf.readTimeout
+			if rdCnt == 0 && sf.idleTimeout > 0 {
+				timeout = sf.idleTimeout
+			}
+			deadline := time.Now().Add(timeout)
+			if rdCnt > 0 && sf.frameTimeout > 0 && frameDeadline.Before(deadline) {
+				deadline = frameDeadline
+			}
+			err = sf.conn.SetReadDeadline(deadline)
 			if err != nil {
 				return
 			}
-			bytesRead, err = io.ReadFull(sf.conn, adu[rdCnt:length])
+			if rdCnt == 0 {
+				// wait for the first byte of a frame, shutdown closes the idle session
+				if !atomic.CompareAndSwapInt32(&sf.state, sessionBusy, sessionIdle) {
+					err = errors.New("server shutdown")
+					return
+				}
+				bytesRead, err = sf.conn.Read(adu[:length])
+				if !atomic.CompareAndSwapInt32(&sf.state, sessionIdle, sessionBusy) {
+					err = errors.New("server shutdown")
+					return
+				}
+			} else {
+				bytesRead, err = io.ReadFull(sf.conn, adu[rdCnt:length])
+			}
 			if err != nil {
 				if err != io.EOF && err != io.ErrClosedPipe || strings.Contains(err.Error(), "use of closed network connection") {
 					return
@@ -63,6 +119,9 @@ func (sf *ServerSession) running(ctx context.Context) {
 				// cnt >0 do nothing
 				// cnt == 0 && err != io.EOF continue do it next
 			}
+			if rdCnt == 0 && bytesRead > 0 {
+				frameDeadline = time.Now().Add(sf.frameTimeout)
+			}
 			rdCnt += bytesRead
 			if rdCnt >= length {
 				// check head ProtocolIdentifier
@@ -72,7 +131,12 @@ func (sf *ServerSession) running(ctx context.Context) {
 				}
 				length = int(binary.BigEndian.Uint16(adu[4:])) + tcpHeaderMbapSize - 1
 				if rdCnt == length {
-					if err = sf.frameHandler(adu[:length]); err != nil {
+					sf.touch()
+					var busy bool
+					if busy, err = sf.acquire(ctx); err != nil {
+						return
+					}
+					if err = sf.frameHandler(adu[:length], busy); err != nil {
 						return
 					}
 				}
@@ -81,8 +145,39 @@ func (sf *ServerSession) running(ctx context.Context) {
 	}
 }
 
-// modbus 包处理
-func (sf *ServerSession) frameHandler(requestAdu []byte) error {
+// acquire applies the rate limit to a request, delays it or reports whether
+// it should be replied server device busy according to the policy.
+func (sf *ServerSession) acquire(ctx context.Context) (busy bool, err error) {
+	now := time.Now()
+	if sf.ratePolicy != RateLimitDelay {
+		if !sf.limiter.allow(now) {
+			return true, nil
+		}
+		if !sf.global.allow(now) { // rejected, the session token is not used
+			sf.limiter.refund()
+			return true, nil
+		}
+		return false, nil
+	}
+
+	wait := sf.limiter.reserve(now)
+	if w := sf.global.reserve(now); w > wait {
+		wait = w
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false, errors.New("server active close")
+		case <-timer.C:
+		}
+	}
+	return false, nil
+}
+
+// modbus 包处理, busy 超过请求速率限制, 应答从站设备忙
+func (sf *ServerSession) frameHandler(requestAdu []byte, busy bool) error {
 	defer func() {
 		if err := recover(); err != nil {
 			sf.Errorf("painc happen,%v", err)
@@ -90,6 +185,7 @@ func (sf *ServerSession) frameHandler(requestAdu []byte) error {
 	}()
 
 	sf.Debugf("RX Raw[% x]", requestAdu)
+	sf.capture(TransportTCP, false, sf.conn, requestAdu)
 	// got head from request adu
 	tcpHeader := protocolTCPHeader{
 		binary.BigEndian.Uint16(requestAdu[0:]),
@@ -99,20 +195,47 @@ func (sf *ServerSession) frameHandler(requestAdu []byte) error {
 	}
 	funcCode := requestAdu[7]
 	pduData := requestAdu[8:]
+	sf.metrics.request(tcpHeader.slaveID, funcCode, len(requestAdu))
 
-	node, err := sf.GetNode(tcpHeader.slaveID)
-	if err != nil { // slave id not exit, ignore it
-		return nil
-	}
 	var rspPduData []byte
-	if handle, ok := sf.function[funcCode]; ok {
-		rspPduData, err = handle(node, pduData)
-	} else {
-		err = &ExceptionError{ExceptionCodeIllegalFunction}
+	var node NodeStorage
+	var err error
+	if sf.forward == nil {
+		node, err = sf.lookupNode(tcpHeader.slaveID)
+	}
+	switch {
+	case sf.forward == nil && node == nil && err == nil: // slave id not exit, ignore it
+		return nil
+	case err != nil: // reply the exception
+	case busy:
+		err = &ExceptionError{ExceptionCodeServerDeviceBusy}
+	case sf.forward != nil: // gateway, relay the downstream response
+		var rspPdu []byte
+		start := time.Now()
+		rspPdu, err = sf.forward(tcpHeader.slaveID, requestAdu[7:])
+		sf.metrics.handled(time.Since(start))
+		if err == nil && len(rspPdu) == 0 { // broadcast, no response
+			return nil
+		}
+		if err == nil {
+			funcCode, rspPduData = rspPdu[0], rspPdu[1:]
+		}
+	default:
+		if handle, ok := sf.function[funcCode]; ok {
+			if !sf.custom[funcCode] { // built-in, attach the write origin for the subscribers
+				node = withOrigin(node, funcCode, sf)
+			}
+			start := time.Now()
+			rspPduData, err = handle(node, pduData)
+			sf.metrics.handled(time.Since(start))
+		} else {
+			err = &ExceptionError{ExceptionCodeIllegalFunction}
+		}
 	}
 	if err != nil {
 		funcCode |= 0x80
-		rspPduData = []byte{err.(*ExceptionError).ExceptionCode}
+		rspPduData = []byte{exceptionCode(err)}
+		sf.metrics.exception(rspPduData[0])
 	}
 
 	// prepare responseAdu data,fill it
@@ -125,6 +248,7 @@ func (sf *ServerSession) frameHandler(requestAdu []byte) error {
 	responseAdu = append(responseAdu, rspPduData...)
 
 	sf.Debugf("TX Raw[% x]", responseAdu)
+	sf.capture(TransportTCP, true, sf.conn, responseAdu)
 	// write response
 	return func(b []byte) error {
 		for wrCnt := 0; len(b) > wrCnt; {
@@ -145,6 +269,7 @@ func (sf *ServerSession) frameHandler(requestAdu []byte) error {
 				// temporary error may be recoverable
 			}
 			wrCnt += byteCount
+			sf.metrics.sent(byteCount)
 		}
 		return nil
 	}(responseAdu)
@@ -0,0 +1,198 @@
+package modbus
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTCPServer_ServeConn(t *testing.T) {
+	srv := NewTCPServer()
+	srv.AddNodes(NewNodeRegister(0x01, 0, 10, 0, 10, 0, 10, 0, 10))
+	local, remote := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeConn(remote) }()
+
+	if !readCoilsRaw(local) {
+		t.Errorf("ServeConn() request not served")
+	}
+	local.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ServeConn() error = %v, wantErr %v", err, nil)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("ServeConn() should return after connection closed")
+	}
+}
+
+func TestTCPServer_Shutdown(t *testing.T) {
+	srv := NewTCPServer()
+	node := NewNodeRegister(0x01, 0, 10, 0, 10, 0, 10, 0, 10)
+	srv.AddNodes(node)
+	// hold the in-flight request until released
+	release := make(chan struct{})
+	srv.RegisterFunctionHandler(0x41, func(reg NodeStorage, data []byte) ([]byte, error) {
+		<-release
+		return data, nil
+	})
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(listen) }()
+
+	idle, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	busy, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	if _, err = busy.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x01, 0x41, 0x55}); err != nil {
+		t.Fatal(err)
+	}
+	for srv.ConnCount() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond) // the request is being handled
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+	if !closedByServer(idle) {
+		t.Errorf("idle connection should be closed by Shutdown")
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Errorf("Serve() error = %v, wantErr %v", err, ErrServerClosed)
+	}
+	close(release)
+	response := make([]byte, 9)
+	_ = busy.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = busy.Read(response); err != nil {
+		t.Fatalf("in-flight request should finish, error = %v", err)
+	}
+	if want := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x01, 0x41, 0x55}; !reflect.DeepEqual(response, want) {
+		t.Errorf("response = % x, want % x", response, want)
+	}
+	if err = <-shutdown; err != nil {
+		t.Errorf("Shutdown() error = %v, wantErr %v", err, nil)
+	}
+}
+
+func TestTCPServer_ShutdownTimeout(t *testing.T) {
+	srv := NewTCPServer()
+	addr := serveTCP(t, srv)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a partial frame keeps the session busy
+	if _, err = conn.Write([]byte{0x00, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() error = %v, wantErr %v", err, context.DeadlineExceeded)
+	}
+	if !closedByServer(conn) {
+		t.Errorf("connection should be force closed")
+	}
+}
+
+func TestTCPServer_RegisterFunctionHandler_node(t *testing.T) {
+	srv := NewTCPServer()
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+	node, err := srv.GetNode(0x01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan NodeStorage, 2)
+	handler := func(reg NodeStorage, data []byte) ([]byte, error) {
+		got <- reg
+		return data, nil
+	}
+	srv.RegisterFunctionHandler(0x41, handler)
+	srv.RegisterFunctionHandler(FuncCodeWriteSingleRegister, handler) // override the built-in
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	exchangeTCP(t, conn, []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x01, 0x41, 0x55})
+	exchangeTCP(t, conn, []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x02})
+	for i := 0; i < 2; i++ {
+		storage := <-got
+		if reg, ok := storage.(*NodeRegister); !ok || reg != node {
+			t.Errorf("handler %d got %T, want the registered *NodeRegister", i, storage)
+		}
+	}
+}
+
+func TestTCPServer_serveAfterClose(t *testing.T) {
+	srv := NewTCPServer()
+	serveTCP(t, srv)
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = srv.Serve(listen); err != ErrServerClosed {
+		t.Errorf("Serve() after Close error = %v, want %v", err, ErrServerClosed)
+	}
+	if err = srv.ListenAndServe("127.0.0.1:0"); err != ErrServerClosed {
+		t.Errorf("ListenAndServe() after Close error = %v, want %v", err, ErrServerClosed)
+	}
+	local, remote := net.Pipe()
+	defer remote.Close()
+	if err = srv.ServeConn(local); err != ErrServerClosed {
+		t.Errorf("ServeConn() after Close error = %v, want %v", err, ErrServerClosed)
+	}
+
+	srv = NewTCPServer()
+	if err = srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = srv.ListenAndServe("127.0.0.1:0"); err != ErrServerClosed {
+		t.Errorf("ListenAndServe() after Shutdown error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestTCPServer_SetUnknownUnitPolicy(t *testing.T) {
+	srv := NewTCPServer()
+	srv.SetUnknownUnitPolicy(UnknownUnitTargetFailed)
+	addr := serveTCP(t, srv)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x09, 0x03, 0x00, 0x00, 0x00, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, 9)
+	if _, err = io.ReadFull(conn, response); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x09, 0x83, 0x0b}; !reflect.DeepEqual(response, want) {
+		t.Errorf("response = % x, want % x", response, want)
+	}
+}
@@ -0,0 +1,347 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SnapshotFormat 快照的编码格式
+type SnapshotFormat byte
+
+// snapshot formats
+const (
+	// SnapshotJSON 可读的json格式
+	SnapshotJSON SnapshotFormat = iota
+	// SnapshotBinary 紧凑的二进制格式, 大端
+	SnapshotBinary
+)
+
+// BitsSnapshot 一段连续位寄存器的快照
+type BitsSnapshot struct {
+	Address uint16 `json:"address"`
+	Values  []bool `json:"values"`
+}
+
+// WordsSnapshot 一段连续16位寄存器的快照
+type WordsSnapshot struct {
+	Address uint16   `json:"address"`
+	Values  []uint16 `json:"values"`
+}
+
+// NodeSnapshot NodeRegister的快照, 每个表按块保存, 首块为NewNodeRegister创建的块.
+type NodeSnapshot struct {
+	SlaveID   byte            `json:"slaveId"`
+	Coils     []BitsSnapshot  `json:"coils"`
+	Discretes []BitsSnapshot  `json:"discretes"`
+	Inputs    []WordsSnapshot `json:"inputs"`
+	Holdings  []WordsSnapshot `json:"holdings"`
+}
+
+// Snapshot 服务器节点集的快照
+type Snapshot struct {
+	Nodes []NodeSnapshot `json:"nodes"`
+}
+
+// Snapshot 获取节点的快照
+func (sf *NodeRegister) Snapshot() NodeSnapshot {
+	sf.rw.RLock()
+	defer sf.rw.RUnlock()
+
+	s := NodeSnapshot{SlaveID: sf.slaveID}
+	bits := func(table Table) []BitsSnapshot {
+		result := make([]BitsSnapshot, 0)
+		for _, b := range sf.bitsBlocks(table) {
+			values := make([]bool, b.quantity)
+			for i := range values {
+				values[i] = getBits(b.bits, uint16(i), 1) > 0
+			}
+			result = append(result, BitsSnapshot{b.addrStart, values})
+		}
+		return result
+	}
+	words := func(table Table) []WordsSnapshot {
+		result := make([]WordsSnapshot, 0)
+		for _, b := range sf.wordsBlocks(table) {
+			result = append(result, WordsSnapshot{b.addrStart, wordsValue(b.words)})
+		}
+		return result
+	}
+	s.Coils, s.Discretes = bits(TableCoils), bits(TableDiscretes)
+	s.Inputs, s.Holdings = words(TableInputs), words(TableHoldings)
+	return s
+}
+
+// Restore 从快照恢复节点的值, 不改变从站地址和块的布局, 快照中的块必须在节点
+// 的地址范围内. 恢复不通知写入订阅.
+func (sf *NodeRegister) Restore(s NodeSnapshot) error {
+	sf.rw.Lock()
+	defer sf.rw.Unlock()
+
+	// check all before modify anything
+	for _, b := range s.Coils {
+		if _, ok := sf.bitsSpan(nil, TableCoils, b.Address, uint16(len(b.Values))); !ok {
+			return errors.New("snapshot coils out of range")
+		}
+	}
+	for _, b := range s.Discretes {
+		if _, ok := sf.bitsSpan(nil, TableDiscretes, b.Address, uint16(len(b.Values))); !ok {
+			return errors.New("snapshot discretes out of range")
+		}
+	}
+	for _, b := range s.Inputs {
+		if _, ok := sf.wordsSpan(nil, TableInputs, b.Address, uint16(len(b.Values))); !ok {
+			return errors.New("snapshot inputs out of range")
+		}
+	}
+	for _, b := range s.Holdings {
+		if _, ok := sf.wordsSpan(nil, TableHoldings, b.Address, uint16(len(b.Values))); !ok {
+			return errors.New("snapshot holdings out of range")
+		}
+	}
+
+	restoreBits := func(table Table, blocks []BitsSnapshot) {
+		for _, b := range blocks {
+			segments, _ := sf.bitsSpan(nil, table, b.Address, uint16(len(b.Values)))
+			pos := 0
+			for _, seg := range segments {
+				for i := uint16(0); i < seg.n; i++ {
+					v := byte(0)
+					if b.Values[pos] {
+						v = 1
+					}
+					setBits(seg.buf, seg.start+i, 1, v)
+					pos++
+				}
+			}
+		}
+	}
+	restoreWords := func(table Table, blocks []WordsSnapshot) {
+		for _, b := range blocks {
+			segments, _ := sf.wordsSpan(nil, table, b.Address, uint16(len(b.Values)))
+			pos := 0
+			for _, seg := range segments {
+				pos += copy(seg, b.Values[pos:])
+			}
+		}
+	}
+	restoreBits(TableCoils, s.Coils)
+	restoreBits(TableDiscretes, s.Discretes)
+	restoreWords(TableInputs, s.Inputs)
+	restoreWords(TableHoldings, s.Holdings)
+	return nil
+}
+
+// NewNodeRegisterFromSnapshot 按快照的块布局创建节点并恢复值.
+func NewNodeRegisterFromSnapshot(s NodeSnapshot) (*NodeRegister, error) {
+	// address and quantity of the blocks of each table, in table order
+	var layout [4][][2]uint16
+	for _, b := range s.Coils {
+		layout[TableCoils] = append(layout[TableCoils], [2]uint16{b.Address, uint16(len(b.Values))})
+	}
+	for _, b := range s.Discretes {
+		layout[TableDiscretes] = append(layout[TableDiscretes], [2]uint16{b.Address, uint16(len(b.Values))})
+	}
+	for _, b := range s.Inputs {
+		layout[TableInputs] = append(layout[TableInputs], [2]uint16{b.Address, uint16(len(b.Values))})
+	}
+	for _, b := range s.Holdings {
+		layout[TableHoldings] = append(layout[TableHoldings], [2]uint16{b.Address, uint16(len(b.Values))})
+	}
+
+	var first [4][2]uint16
+	for table, blocks := range layout {
+		if len(blocks) > 0 {
+			first[table] = blocks[0]
+		}
+	}
+	node := NewNodeRegister(s.SlaveID,
+		first[TableCoils][0], first[TableCoils][1],
+		first[TableDiscretes][0], first[TableDiscretes][1],
+		first[TableInputs][0], first[TableInputs][1],
+		first[TableHoldings][0], first[TableHoldings][1])
+	for table, blocks := range layout {
+		for i := 1; i < len(blocks); i++ {
+			if err := node.AddBlock(Table(table), blocks[i][0], blocks[i][1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := node.Restore(s); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// Snapshot 获取所有NodeRegister节点的快照
+func (sf *serverCommon) Snapshot() Snapshot {
+	s := Snapshot{Nodes: make([]NodeSnapshot, 0)}
+	sf.Range(func(slaveID byte, node *NodeRegister) bool {
+		s.Nodes = append(s.Nodes, node.Snapshot())
+		return true
+	})
+	return s
+}
+
+// Restore 从快照恢复节点集, 已有的NodeRegister节点恢复值, 没有的节点按快照创建.
+func (sf *serverCommon) Restore(s Snapshot) error {
+	for _, ns := range s.Nodes {
+		v, err := sf.GetStorage(ns.SlaveID)
+		if err != nil {
+			node, err := NewNodeRegisterFromSnapshot(ns)
+			if err != nil {
+				return err
+			}
+			sf.AddNodes(node)
+			continue
+		}
+		node, ok := v.(*NodeRegister)
+		if !ok {
+			return errors.New("slaveID storage is not NodeRegister")
+		}
+		if err = node.Restore(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotMagic 二进制快照的文件头和版本
+var snapshotMagic = []byte{'M', 'B', 'S', 'N', 1}
+
+// EncodeSnapshot 以format编码快照写入w.
+func EncodeSnapshot(w io.Writer, s Snapshot, format SnapshotFormat) error {
+	if format == SnapshotJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	bw := bufio.NewWriter(w)
+	put := func(v ...interface{}) {
+		for _, x := range v {
+			_ = binary.Write(bw, binary.BigEndian, x)
+		}
+	}
+	put(snapshotMagic, uint16(len(s.Nodes)))
+	for _, n := range s.Nodes {
+		put(n.SlaveID)
+		for _, blocks := range [][]BitsSnapshot{n.Coils, n.Discretes} {
+			put(uint16(len(blocks)))
+			for _, b := range blocks {
+				bits := make([]byte, (len(b.Values)+7)/8)
+				for i, v := range b.Values {
+					if v {
+						setBits(bits, uint16(i), 1, 1)
+					}
+				}
+				put(b.Address, uint16(len(b.Values)), bits)
+			}
+		}
+		for _, blocks := range [][]WordsSnapshot{n.Inputs, n.Holdings} {
+			put(uint16(len(blocks)))
+			for _, b := range blocks {
+				put(b.Address, uint16(len(b.Values)), b.Values)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// DecodeSnapshot 从r读取format编码的快照.
+func DecodeSnapshot(r io.Reader, format SnapshotFormat) (Snapshot, error) {
+	var s Snapshot
+	if format == SnapshotJSON {
+		err := json.NewDecoder(r).Decode(&s)
+		return s, err
+	}
+
+	var err error
+	br := bufio.NewReader(r)
+	get := func(v ...interface{}) {
+		for _, x := range v {
+			if err == nil {
+				err = binary.Read(br, binary.BigEndian, x)
+			}
+		}
+	}
+	var nodes, blocks, address, quantity uint16
+	magic := make([]byte, len(snapshotMagic))
+	get(magic, &nodes)
+	if err != nil {
+		return s, err
+	}
+	if string(magic) != string(snapshotMagic) {
+		return s, errors.New("invalid snapshot magic or version")
+	}
+	s.Nodes = make([]NodeSnapshot, nodes)
+	for i := range s.Nodes {
+		n := &s.Nodes[i]
+		get(&n.SlaveID)
+		for _, dst := range []*[]BitsSnapshot{&n.Coils, &n.Discretes} {
+			get(&blocks)
+			*dst = make([]BitsSnapshot, 0, blocks)
+			for j := 0; j < int(blocks) && err == nil; j++ {
+				get(&address, &quantity)
+				bits := make([]byte, (int(quantity)+7)/8)
+				get(bits)
+				values := make([]bool, quantity)
+				for k := range values {
+					values[k] = getBits(bits, uint16(k), 1) > 0
+				}
+				*dst = append(*dst, BitsSnapshot{address, values})
+			}
+		}
+		for _, dst := range []*[]WordsSnapshot{&n.Inputs, &n.Holdings} {
+			get(&blocks)
+			*dst = make([]WordsSnapshot, 0, blocks)
+			for j := 0; j < int(blocks) && err == nil; j++ {
+				get(&address, &quantity)
+				values := make([]uint16, quantity)
+				get(values)
+				*dst = append(*dst, WordsSnapshot{address, values})
+			}
+		}
+		if err != nil {
+			return Snapshot{}, err
+		}
+	}
+	return s, nil
+}
+
+// SaveSnapshotFile 以format编码快照保存到文件, 先写临时文件再重命名, 不会
+// 因中途失败而破坏已有的文件.
+func SaveSnapshotFile(path string, s Snapshot, format SnapshotFormat) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op after renamed
+	if err = EncodeSnapshot(f, s, format); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadSnapshotFile 从文件读取format编码的快照.
+func LoadSnapshotFile(path string, format SnapshotFormat) (Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer f.Close()
+	return DecodeSnapshot(f, format)
+}
@@ -0,0 +1,93 @@
+package modbus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AutosaveConfig 自动保存快照的配置
+type AutosaveConfig struct {
+	Path   string
+	Format SnapshotFormat
+	// Interval 有写入时周期保存, 0 不周期保存
+	Interval time.Duration
+	// OnWrite 每次写入后立即保存, 写入频繁时合并保存
+	OnWrite bool
+	// OnError 保存失败时调用, 可以为nil
+	OnError func(err error)
+}
+
+// Autosave 自动保存所有NodeRegister节点的快照到文件, 仅跟踪调用时已有节点的写入,
+// 返回停止函数, 停止时若有未保存的写入则保存一次.
+func (sf *serverCommon) Autosave(cfg AutosaveConfig) (stop func() error) {
+	var mu sync.Mutex // serialize saves
+	var dirty int32   // atomic, writers must not wait for the save
+	var cancels []func()
+
+	save := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !atomic.CompareAndSwapInt32(&dirty, 1, 0) {
+			return nil
+		}
+		err := SaveSnapshotFile(cfg.Path, sf.Snapshot(), cfg.Format)
+		if err != nil {
+			atomic.StoreInt32(&dirty, 1) // try again next time
+			if cfg.OnError != nil {
+				cfg.OnError(err)
+			}
+		}
+		return err
+	}
+
+	written := make(chan struct{}, 1)
+	sf.Range(func(slaveID byte, node *NodeRegister) bool {
+		cancels = append(cancels, node.subscribeAll(func(WriteEvent) {
+			atomic.StoreInt32(&dirty, 1)
+			select {
+			case written <- struct{}{}:
+			default: // a save is pending already
+			}
+		}))
+		return true
+	})
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		var tick <-chan time.Time
+		if cfg.Interval > 0 {
+			ticker := time.NewTicker(cfg.Interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case <-written:
+				if cfg.OnWrite {
+					_ = save()
+				}
+			case <-tick:
+				_ = save()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() error {
+		var err error
+		once.Do(func() {
+			for _, cancel := range cancels {
+				cancel()
+			}
+			close(done)
+			<-exited
+			err = save()
+		})
+		return err
+	}
+}
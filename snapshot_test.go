@@ -0,0 +1,115 @@
+package modbus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newSnapshotNode(t *testing.T) *NodeRegister {
+	t.Helper()
+	node := NewNodeRegister(0x05, 0, 10, 0, 0, 0, 2, 0, 3)
+	if err := node.AddBlock(TableHoldings, 40000, 2); err != nil {
+		t.Fatal(err)
+	}
+	_ = node.WriteCoils(0, 10, []byte{0x81, 0x02})
+	_ = node.WriteInputs(0, []uint16{0x1111, 0x2222})
+	_ = node.WriteHoldings(1, []uint16{0x1234, 0x5678})
+	_ = node.WriteHoldings(40000, []uint16{0xabcd, 0xef01})
+	return node
+}
+
+func TestNodeRegister_Snapshot(t *testing.T) {
+	s := newSnapshotNode(t).Snapshot()
+	want := NodeSnapshot{
+		SlaveID:   0x05,
+		Coils:     []BitsSnapshot{{0, []bool{true, false, false, false, false, false, false, true, false, true}}},
+		Discretes: []BitsSnapshot{{0, []bool{}}},
+		Inputs:    []WordsSnapshot{{0, []uint16{0x1111, 0x2222}}},
+		Holdings:  []WordsSnapshot{{0, []uint16{0, 0x1234, 0x5678}}, {40000, []uint16{0xabcd, 0xef01}}},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("Snapshot() = %+v, want %+v", s, want)
+	}
+
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		var buf bytes.Buffer
+		if err := EncodeSnapshot(&buf, Snapshot{[]NodeSnapshot{s}}, format); err != nil {
+			t.Fatalf("EncodeSnapshot() error = %v, wantErr %v", err, nil)
+		}
+		got, err := DecodeSnapshot(&buf, format)
+		if err != nil {
+			t.Fatalf("DecodeSnapshot() error = %v, wantErr %v", err, nil)
+		}
+		if !reflect.DeepEqual(got, Snapshot{[]NodeSnapshot{s}}) {
+			t.Errorf("DecodeSnapshot() format %v = %+v, want %+v", format, got, s)
+		}
+	}
+	if _, err := DecodeSnapshot(bytes.NewReader([]byte("MBSN\x09\x00\x00")), SnapshotBinary); err == nil {
+		t.Errorf("DecodeSnapshot() error = %v, wantErr %v", err, true)
+	}
+
+	node, err := NewNodeRegisterFromSnapshot(s)
+	if err != nil {
+		t.Fatalf("NewNodeRegisterFromSnapshot() error = %v, wantErr %v", err, nil)
+	}
+	if got := node.Snapshot(); !reflect.DeepEqual(got, s) {
+		t.Errorf("NewNodeRegisterFromSnapshot() snapshot = %+v, want %+v", got, s)
+	}
+	// does not fit the layout
+	if err = NewNodeRegister(0x05, 0, 10, 0, 0, 0, 2, 0, 3).Restore(s); err == nil {
+		t.Errorf("Restore() error = %v, wantErr %v", err, true)
+	}
+}
+
+func TestServer_Autosave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nodes.bin")
+
+	sc := newServerCommon()
+	node := newSnapshotNode(t)
+	sc.AddNodes(node)
+	stop := sc.Autosave(AutosaveConfig{Path: path, Format: SnapshotBinary, OnWrite: true})
+	if _, err = funcWriteSingleRegister(withOrigin(node, FuncCodeWriteSingleRegister, nil),
+		[]byte{0x9c, 0x41, 0x00, 0x2a}); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved Snapshot
+	for i := 0; i < 100; i++ {
+		if saved, err = LoadSnapshotFile(path, SnapshotBinary); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("LoadSnapshotFile() error = %v, wantErr %v", err, nil)
+	}
+	if got := saved.Nodes[0].Holdings[1].Values[1]; got != 0x2a {
+		t.Errorf("saved holding 40001 = %#x, want %#x", got, 0x2a)
+	}
+	if err = stop(); err != nil {
+		t.Errorf("stop() error = %v, wantErr %v", err, nil)
+	}
+
+	// restore the whole node set into a new server
+	other := newServerCommon()
+	if err = other.Restore(saved); err != nil {
+		t.Fatalf("Restore() error = %v, wantErr %v", err, nil)
+	}
+	restored, err := other.GetNode(0x05)
+	if err != nil {
+		t.Fatalf("GetNode() error = %v, wantErr %v", err, nil)
+	}
+	if got := restored.Snapshot(); !reflect.DeepEqual(got, node.Snapshot()) {
+		t.Errorf("restored snapshot = %+v, want %+v", got, node.Snapshot())
+	}
+}
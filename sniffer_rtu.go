@@ -0,0 +1,250 @@
+package modbus
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// Exchange 监听到的一次请求应答
+type Exchange struct {
+	SlaveID   byte
+	FuncCode  byte // without the exception bit
+	Address   uint16
+	Quantity  uint16
+	Values    []uint16 // 请求写入的值或应答读出的值, 位为0或1
+	Exception byte     // 异常码, 0表示正常应答
+	Request   []byte   // request adu
+	Response  []byte   // response adu, nil for broadcast or no response
+	// RequestTime 请求帧结束的时间, ResponseTime 应答帧结束的时间, 没有应答时为零值
+	RequestTime  time.Time
+	ResponseTime time.Time
+	// Err 帧校验失败(Request为该帧)或请求没有应答
+	Err error
+}
+
+// Latency 应答时间, 没有应答时为0
+func (e Exchange) Latency() time.Duration {
+	if e.ResponseTime.IsZero() {
+		return 0
+	}
+	return e.ResponseTime.Sub(e.RequestTime)
+}
+
+// String implements fmt.Stringer.
+func (e Exchange) String() string {
+	switch {
+	case e.Err != nil && e.Response == nil && e.FuncCode == 0:
+		return fmt.Sprintf("invalid frame [% x], %v", e.Request, e.Err)
+	case e.Exception != 0:
+		return fmt.Sprintf("slave %d func %d address %d quantity %d exception %d(%v), latency %v",
+			e.SlaveID, e.FuncCode, e.Address, e.Quantity, e.Exception, &ExceptionError{e.Exception}, e.Latency())
+	case e.Err != nil:
+		return fmt.Sprintf("slave %d func %d address %d quantity %d values %v, %v",
+			e.SlaveID, e.FuncCode, e.Address, e.Quantity, e.Values, e.Err)
+	default:
+		return fmt.Sprintf("slave %d func %d address %d quantity %d values %v, latency %v",
+			e.SlaveID, e.FuncCode, e.Address, e.Quantity, e.Values, e.Latency())
+	}
+}
+
+// RTUSniffer 串口RTU总线监听器, 只读不写, 以t3.5静默分帧并校验CRC, 将请求与
+// 应答配对后解码为Exchange交给回调, 没有回调时输出到日志(需LogMode(true)).
+type RTUSniffer struct {
+	serial.Config
+	mu              sync.Mutex
+	t35             time.Duration // 0 means derive it from the baud rate
+	responseTimeout time.Duration
+	handler         func(Exchange)
+	port            io.ReadCloser
+	closed          bool
+	logger
+}
+
+// NewRTUSniffer new rtu bus monitor on the serial config, handler is called
+// for every exchange in the monitor goroutine, nil means log it.
+func NewRTUSniffer(config serial.Config, handler func(Exchange)) *RTUSniffer {
+	return &RTUSniffer{
+		Config:          config,
+		responseTimeout: SerialDefaultTimeout,
+		handler:         handler,
+		logger:          newLogger("modbusRTUSniffer => "),
+	}
+}
+
+// SetFrameSilence 设置t3.5帧间静默时间, t35 <= 0 由波特率计算, 默认由波特率计算.
+func (sf *RTUSniffer) SetFrameSilence(t35 time.Duration) *RTUSniffer {
+	sf.mu.Lock()
+	sf.t35 = t35
+	sf.mu.Unlock()
+	return sf
+}
+
+// SetResponseTimeout 设置等待应答的超时, 超时的请求记为没有应答, 默认SerialDefaultTimeout.
+func (sf *RTUSniffer) SetResponseTimeout(t time.Duration) *RTUSniffer {
+	sf.mu.Lock()
+	sf.responseTimeout = t
+	sf.mu.Unlock()
+	return sf
+}
+
+// ListenAndServe open the serial port and monitor it, see Serve.
+func (sf *RTUSniffer) ListenAndServe() error {
+	port, err := serial.Open(&sf.Config)
+	if err != nil {
+		return err
+	}
+	return sf.Serve(port)
+}
+
+// Serve monitor the port until Close or the port failed, always returns
+// a non-nil error, ErrServerClosed after Close.
+func (sf *RTUSniffer) Serve(port io.ReadCloser) error {
+	sf.mu.Lock()
+	if sf.closed {
+		sf.mu.Unlock()
+		port.Close()
+		return ErrServerClosed
+	}
+	sf.port = port
+	t35, responseTimeout := sf.t35, sf.responseTimeout
+	if t35 <= 0 {
+		t35 = calculateSilence(sf.BaudRate)
+	}
+	sf.mu.Unlock()
+
+	recv := newFrameReceiver(port, t35)
+	var pending *Exchange
+	for {
+		var timeout time.Duration
+		if pending != nil {
+			timeout = responseTimeout
+		}
+		adu, err := recv.receive(timeout, rtuAduMaxSize)
+		now := time.Now()
+		if n := recv.takeDropped(); n > 0 {
+			sf.Debugf("receive buffer full, dropped %d bytes", n)
+		}
+		switch {
+		case err == nil:
+			pending = sf.frame(pending, adu, now)
+		case pending != nil && isTimeout(err):
+			pending.Err = err
+			sf.emit(pending)
+			pending = nil
+		default:
+			sf.mu.Lock()
+			closed := sf.closed
+			sf.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			port.Close()
+			return err
+		}
+	}
+}
+
+// Close close the serial port, Serve returns ErrServerClosed.
+func (sf *RTUSniffer) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.closed = true
+	if sf.port == nil {
+		return nil
+	}
+	return sf.port.Close()
+}
+
+// frame 处理一帧, 与等待应答的请求配对, 返回新的等待应答的请求
+func (sf *RTUSniffer) frame(pending *Exchange, adu []byte, now time.Time) *Exchange {
+	slaveID, pdu, err := decodeRTUFrame(adu)
+	if err != nil {
+		sf.emit(&Exchange{Request: adu, RequestTime: now, Err: err})
+		return pending
+	}
+
+	if pending != nil {
+		if slaveID == pending.SlaveID && pdu[0]&0x7f == pending.FuncCode {
+			// a retried request has the same slave id and function code
+			if d := Dissect(TransportRTU, DirectionResponse, adu); isResponse(pending, d) {
+				pending.Response, pending.ResponseTime = adu, now
+				decodeResponse(pending, d)
+				sf.emit(pending)
+				return nil
+			}
+		}
+		// not the response, the request is not responded
+		pending.Err = &TimeoutError{serial.ErrTimeout}
+		sf.emit(pending)
+	}
+
+	ex := &Exchange{SlaveID: slaveID, FuncCode: pdu[0] & 0x7f, Request: adu, RequestTime: now}
+	decodeRequest(ex, adu)
+	if slaveID == AddressBroadCast {
+		sf.emit(ex)
+		return nil
+	}
+	return ex
+}
+
+func (sf *RTUSniffer) emit(ex *Exchange) {
+	if sf.handler != nil {
+		sf.handler(*ex)
+		return
+	}
+	sf.Debugf("%v", ex)
+}
+
+// isTimeout reports whether err is a timeout.
+func isTimeout(err error) bool {
+	e, ok := err.(interface{ Timeout() bool })
+	return ok && e.Timeout()
+}
+
+// decodeRequest 解码请求的地址, 数量和写入的值
+func decodeRequest(ex *Exchange, adu []byte) {
+	d := Dissect(TransportRTU, DirectionRequest, adu)
+	ex.Address, ex.Quantity = d.Address, d.Quantity
+	if ex.FuncCode != FuncCodeReadWriteMultipleRegisters { // values are the read result
+		ex.Values = d.Values
+	}
+}
+
+// isResponse 应答帧d是否是请求ex的有效应答, 字节数或回显须与请求一致.
+// 写单个线圈或寄存器的应答与请求相同, 无法区分重发的请求.
+func isResponse(ex *Exchange, d *Dissection) bool {
+	switch {
+	case d.FuncCode&0x80 != 0: // requests never have the exception bit
+		return true
+	case !d.Valid():
+		return false
+	case ex.FuncCode == FuncCodeReadCoils, ex.FuncCode == FuncCodeReadDiscreteInputs:
+		return d.ByteCount == (int(ex.Quantity)+7)/8
+	case ex.FuncCode == FuncCodeReadHoldingRegisters, ex.FuncCode == FuncCodeReadInputRegisters,
+		ex.FuncCode == FuncCodeReadWriteMultipleRegisters:
+		return d.ByteCount == int(ex.Quantity)*2
+	case ex.FuncCode == FuncCodeWriteMultipleCoils, ex.FuncCode == FuncCodeWriteMultipleRegisters:
+		return d.Address == ex.Address && d.Quantity == ex.Quantity
+	default:
+		return true
+	}
+}
+
+// decodeResponse 解码应答的异常或读出的值
+func decodeResponse(ex *Exchange, d *Dissection) {
+	ex.Exception = d.Exception
+	switch {
+	case d.Exception != 0:
+	case ex.FuncCode == FuncCodeReadCoils, ex.FuncCode == FuncCodeReadDiscreteInputs:
+		if len(d.Values) >= int(ex.Quantity) {
+			ex.Values = d.Values[:ex.Quantity]
+		}
+	case ex.FuncCode == FuncCodeReadHoldingRegisters, ex.FuncCode == FuncCodeReadInputRegisters,
+		ex.FuncCode == FuncCodeReadWriteMultipleRegisters:
+		ex.Values = d.Values
+	}
+}
@@ -0,0 +1,113 @@
+package modbus
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+func TestRTUSniffer(t *testing.T) {
+	port := newPipePort()
+	exchanges := make(chan Exchange, 8)
+	sniffer := NewRTUSniffer(serial.Config{BaudRate: 19200}, func(ex Exchange) { exchanges <- ex }).
+		SetFrameSilence(5 * time.Millisecond).
+		SetResponseTimeout(50 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() { done <- sniffer.Serve(port) }()
+
+	frames := [][]byte{
+		rtuFrame(0x01, 0x03, 0x00, 0x10, 0x00, 0x02),
+		rtuFrame(0x01, 0x03, 0x04, 0x12, 0x34, 0x56, 0x78),
+		rtuFrame(0x02, 0x0f, 0x00, 0x01, 0x00, 0x03, 0x01, 0x05),
+		rtuFrame(0x02, 0x8f, 0x02),
+		{0x01, 0x02, 0x03, 0x04, 0x05},
+		rtuFrame(0x00, 0x06, 0x00, 0x01, 0x00, 0x0a),
+		rtuFrame(0x03, 0x01, 0x00, 0x00, 0x00, 0x08),
+	}
+	for _, frame := range frames {
+		if _, err := port.remote.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(15 * time.Millisecond)
+	}
+
+	want := []Exchange{
+		{SlaveID: 0x01, FuncCode: 0x03, Address: 0x10, Quantity: 2, Values: []uint16{0x1234, 0x5678},
+			Request: frames[0], Response: frames[1]},
+		{SlaveID: 0x02, FuncCode: 0x0f, Address: 0x01, Quantity: 3, Values: []uint16{1, 0, 1},
+			Exception: 0x02, Request: frames[2], Response: frames[3]},
+		{Request: frames[4], Err: ErrCRCMismatch},
+		{SlaveID: 0x00, FuncCode: 0x06, Address: 0x01, Quantity: 1, Values: []uint16{0x0a}, Request: frames[5]},
+		{SlaveID: 0x03, FuncCode: 0x01, Quantity: 8, Request: frames[6], Err: ErrTimeout},
+	}
+	for i, w := range want {
+		var got Exchange
+		select {
+		case got = <-exchanges:
+		case <-time.After(time.Second):
+			t.Fatalf("exchange %d not emitted", i)
+		}
+		if !errors.Is(got.Err, w.Err) {
+			t.Errorf("exchange %d error = %v, want %v", i, got.Err, w.Err)
+		}
+		if w.Response != nil && got.Latency() <= 0 {
+			t.Errorf("exchange %d latency = %v, want > 0", i, got.Latency())
+		}
+		got.Err, got.RequestTime, got.ResponseTime, w.Err = nil, time.Time{}, time.Time{}, nil
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("exchange %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	sniffer.Close()
+	if err := <-done; err != ErrServerClosed {
+		t.Errorf("Serve() error = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestRTUSniffer_retry(t *testing.T) {
+	port := newPipePort()
+	exchanges := make(chan Exchange, 8)
+	sniffer := NewRTUSniffer(serial.Config{BaudRate: 19200}, func(ex Exchange) { exchanges <- ex }).
+		SetFrameSilence(5 * time.Millisecond).
+		SetResponseTimeout(50 * time.Millisecond)
+	go func() { _ = sniffer.Serve(port) }()
+	defer sniffer.Close()
+
+	// the master retries the read without a response, then the slave responds
+	frames := [][]byte{
+		rtuFrame(0x01, 0x03, 0x00, 0x10, 0x00, 0x02),
+		rtuFrame(0x01, 0x03, 0x00, 0x10, 0x00, 0x02),
+		rtuFrame(0x01, 0x03, 0x04, 0x12, 0x34, 0x56, 0x78),
+	}
+	for _, frame := range frames {
+		if _, err := port.remote.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(15 * time.Millisecond)
+	}
+
+	want := []Exchange{
+		{SlaveID: 0x01, FuncCode: 0x03, Address: 0x10, Quantity: 2, Request: frames[0], Err: ErrTimeout},
+		{SlaveID: 0x01, FuncCode: 0x03, Address: 0x10, Quantity: 2, Values: []uint16{0x1234, 0x5678},
+			Request: frames[1], Response: frames[2]},
+	}
+	for i, w := range want {
+		var got Exchange
+		select {
+		case got = <-exchanges:
+		case <-time.After(time.Second):
+			t.Fatalf("exchange %d not emitted", i)
+		}
+		if !errors.Is(got.Err, w.Err) {
+			t.Errorf("exchange %d error = %v, want %v", i, got.Err, w.Err)
+		}
+		got.Err, got.RequestTime, got.ResponseTime, w.Err = nil, time.Time{}, time.Time{}, nil
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("exchange %d = %+v, want %+v", i, got, w)
+		}
+	}
+}